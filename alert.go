@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// AlertRule fires when a reading of Metric goes below or above a threshold
+type AlertRule struct {
+	Metric string   `yaml:"metric"`
+	Below  *float64 `yaml:"below"`
+	Above  *float64 `yaml:"above"`
+}
+
+// breached reports whether value violates the rule, and which side it crossed
+func (r AlertRule) breached(value float64) (string, float64, bool) {
+	if r.Below != nil && value < *r.Below {
+		return "below", *r.Below, true
+	}
+	if r.Above != nil && value > *r.Above {
+		return "above", *r.Above, true
+	}
+	return "", 0, false
+}
+
+// alertPayload is the JSON body POSTed to the webhook
+type alertPayload struct {
+	Account   string  `json:"account,omitempty"`
+	Metric    string  `json:"metric"`
+	Condition string  `json:"condition"` // "below" or "above"
+	Threshold float64 `json:"threshold"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"` // unix seconds
+}
+
+// alertEvaluator checks new readings against the rules. A rule fires once
+// when a series enters a breach and re-arms only after a reading back within
+// the threshold, so a sustained breach doesn't alert every cycle.
+type alertEvaluator struct {
+	rules   []AlertRule
+	url     string
+	client  *http.Client
+	mu      sync.Mutex
+	firing  map[string]bool // account/metric/rule index -> currently breached
+	pending []alertPayload
+}
+
+// alerts is nil unless serve mode was started with rules and a webhook
+var alerts *alertEvaluator
+
+func newAlertEvaluator(url string, rules []AlertRule, client *http.Client) *alertEvaluator {
+	return &alertEvaluator{
+		rules:  rules,
+		url:    url,
+		client: client,
+		firing: make(map[string]bool),
+	}
+}
+
+// observe evaluates one new reading; fired alerts are queued for flush
+func (a *alertEvaluator) observe(account, metric string, value float64, ts int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, rule := range a.rules {
+		if rule.Metric != metric {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%d", account, metric, i)
+		condition, threshold, breached := rule.breached(value)
+		if breached && !a.firing[key] {
+			a.pending = append(a.pending, alertPayload{
+				Account:   account,
+				Metric:    metric,
+				Condition: condition,
+				Threshold: threshold,
+				Value:     value,
+				Timestamp: ts,
+			})
+		}
+		a.firing[key] = breached
+	}
+}
+
+// flush POSTs every queued alert to the webhook. Failures are logged and
+// dropped so a broken webhook never blocks pushing.
+func (a *alertEvaluator) flush() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	for _, alert := range pending {
+		log.Printf("Alert: %s %s %g (value %g @ %d)", alert.Metric, alert.Condition, alert.Threshold, alert.Value, alert.Timestamp)
+		if err := a.send(alert); err != nil {
+			log.Printf("Alert webhook error: %v", err)
+		}
+	}
+}
+
+func (a *alertEvaluator) send(alert alertPayload) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// validateAlerts checks that every rule names a pushed metric and a threshold
+func (c *Config) validateAlerts() error {
+	for i, rule := range c.Alerts {
+		config, ok := metricRegistry[rule.Metric]
+		if !ok || config.MetricType != "timeseries" && config.MetricType != "simple" {
+			return fmt.Errorf("alert %d: %q is not a timeseries or simple metric", i+1, rule.Metric)
+		}
+		if rule.Below == nil && rule.Above == nil {
+			return fmt.Errorf("alert %d: set below or above", i+1)
+		}
+	}
+	if len(c.Alerts) > 0 && c.AlertWebhookURL == "" {
+		return fmt.Errorf("alerts configured without alert_webhook_url")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateAlertsAcceptsSimpleMetrics(t *testing.T) {
+	above := 65.0
+	cfg := defaultConfig()
+	cfg.AlertWebhookURL = "http://127.0.0.1:1/hook"
+	cfg.Alerts = []AlertRule{{Metric: "sleep_rhr", Above: &above}, {Metric: "hr", Above: &above}}
+	if err := cfg.validateAlerts(); err != nil {
+		t.Fatalf("validateAlerts: %v", err)
+	}
+	cfg.Alerts = []AlertRule{{Metric: "sleep", Above: &above}}
+	if err := cfg.validateAlerts(); err == nil {
+		t.Error("validateAlerts accepted the composite sleep metric")
+	}
+}
+
+func TestSimpleMetricAlerts(t *testing.T) {
+	var received []alertPayload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert alertPayload
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		received = append(received, alert)
+	}))
+	defer webhook.Close()
+
+	above := 65.0
+	defer func(previous *alertEvaluator) { alerts = previous }(alerts)
+	alerts = newAlertEvaluator(webhook.URL, []AlertRule{{Metric: "sleep_rhr", Above: &above}}, webhook.Client())
+
+	f := testFetcher(t)
+	metrics := []Metric{timeseriesMetric(t, "sleep_rhr", map[string]any{"value": 70, "day_start_timestamp": 1704067200})}
+	if err := f.pushMetrics(metrics, &recordingExporter{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("webhook got %d alerts, want 1", len(received))
+	}
+	if alert := received[0]; alert.Metric != "sleep_rhr" || alert.Value != 70 || alert.Timestamp != 1704067200 {
+		t.Errorf("alert %+v, want sleep_rhr 70 @ 1704067200", alert)
+	}
+}
+
+func TestAlertsOnlyForWrittenReadings(t *testing.T) {
+	var received []alertPayload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert alertPayload
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		received = append(received, alert)
+	}))
+	defer webhook.Close()
+
+	above := 30.0
+	defer func(previous *alertEvaluator) { alerts = previous }(alerts)
+	alerts = newAlertEvaluator(webhook.URL, []AlertRule{{Metric: "hr", Above: &above}, {Metric: "hrv", Above: &above}}, webhook.Client())
+
+	metrics := []Metric{
+		timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate", "values": readings(60, 100)}),
+		timeseriesMetric(t, "hrv", map[string]any{"title": "HRV", "values": readings(40, 100)}),
+	}
+
+	// A failed write fires nothing
+	f := testFetcher(t)
+	if err := f.pushMetrics(metrics, &recordingExporter{err: errors.New("connection refused")}); err == nil {
+		t.Fatal("pushMetrics succeeded with a failing exporter")
+	}
+	if len(received) != 0 {
+		t.Fatalf("webhook got %d alerts for a failed write, want 0", len(received))
+	}
+
+	// With --keep-going only the metric that was written fires; the rejected
+	// one fires once its retry goes through
+	f.cfg.KeepGoing = true
+	exporter := &failingMetricExporter{fail: "ultrahuman_hrv_ms"}
+	f.pushMetrics(metrics, exporter)
+	if len(received) != 1 || received[0].Metric != "hr" {
+		t.Fatalf("webhook got %+v, want only the hr alert", received)
+	}
+	exporter.fail = ""
+	if err := f.pushMetrics(metrics, exporter); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || received[1].Metric != "hrv" || received[1].Timestamp != 100 {
+		t.Errorf("webhook got %+v, want the hrv alert @ 100 after the retry", received)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// backfillSummary is printed after a backfill run
+type backfillSummary struct {
+	Days            int      `json:"days"`
+	EmptyDays       []string `json:"empty_days"`  // fetched but returned no metrics
+	FailedDays      []string `json:"failed_days"` // fetch failed, nothing pushed
+	DurationSeconds float64  `json:"duration_seconds"`
+	*pushStats
+}
+
+// runBackfill backfills every configured account in turn and prints a summary
+func runBackfill(cfg *Config, client *http.Client) error {
+	if cfg.BackfillDays <= 0 {
+		return fmt.Errorf("--days must be positive")
+	}
+
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.logTimezone()
+
+	start := time.Now()
+	summary := &backfillSummary{EmptyDays: []string{}, FailedDays: []string{}, pushStats: newPushStats()}
+	for _, fetcher := range newFetchers(cfg, client, apiURL) {
+		fetcher.stats = summary.pushStats
+		if err := backfillAccount(cfg, fetcher, exporter, summary); err != nil {
+			return err
+		}
+	}
+	summary.DurationSeconds = time.Since(start).Seconds()
+	summary.print(os.Stdout, cfg.Output)
+	return nil
+}
+
+// backfillAccount fetches the cfg.BackfillDays days up to --date with a bounded pool
+// of cfg.Concurrency workers, then pushes them oldest first so the per-metric
+// dedup timestamps only move forward.
+func backfillAccount(cfg *Config, fetcher *Fetcher, exporter Exporter, summary *backfillSummary) error {
+	workers := max(cfg.Concurrency, 1)
+
+	last, _ := time.Parse("2006-01-02", cfg.queryDate())
+	dates := make([]string, cfg.BackfillDays)
+	for i := range dates {
+		dates[i] = last.AddDate(0, 0, -(cfg.BackfillDays - 1 - i)).Format("2006-01-02")
+	}
+
+	responses := make([]*APIResponse, len(dates))
+	errs := make([]error, len(dates))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				responses[i], errs[i] = fetcher.Fetch(context.Background(), dates[i])
+			}
+		}()
+	}
+	for i := range dates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.Printf("Fetched %d days with %d workers", len(dates), workers)
+
+	for i, date := range dates {
+		label := date
+		if fetcher.account.Label != "" {
+			label = fetcher.account.Label + " " + date
+		}
+		summary.Days++
+		if errs[i] != nil {
+			log.Printf("Backfill %s: fetch error: %v", date, errs[i])
+			summary.FailedDays = append(summary.FailedDays, label)
+			continue
+		}
+		if responseEmpty(responses[i]) {
+			summary.EmptyDays = append(summary.EmptyDays, label)
+		}
+		if err := fetcher.Push(responses[i], exporter); err != nil {
+			return fmt.Errorf("backfill %s: %w", date, err)
+		}
+	}
+	return nil
+}
+
+// responseEmpty reports whether a response carries no metrics at all
+func responseEmpty(resp *APIResponse) bool {
+	for _, metrics := range resp.Data.Metrics {
+		if len(metrics) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// print writes the summary as a table, or as JSON with --output json
+func (s *backfillSummary) print(w io.Writer, output string) {
+	if output == "json" {
+		newJSONEncoder(w).Encode(s)
+		return
+	}
+
+	fmt.Fprintf(w, "Days processed: %d (%d empty, %d failed) in %s\n",
+		s.Days, len(s.EmptyDays), len(s.FailedDays), time.Duration(s.DurationSeconds*float64(time.Second)).Round(time.Millisecond))
+	for _, day := range s.EmptyDays {
+		fmt.Fprintf(w, "  no data: %s\n", day)
+	}
+	for _, day := range s.FailedDays {
+		fmt.Fprintf(w, "  fetch failed: %s\n", day)
+	}
+
+	metrics := make([]string, 0, len(s.Pushed)+len(s.Duplicates))
+	for metric := range s.Pushed {
+		metrics = append(metrics, metric)
+	}
+	for metric := range s.Duplicates {
+		if _, ok := s.Pushed[metric]; !ok {
+			metrics = append(metrics, metric)
+		}
+	}
+	sort.Strings(metrics)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tPUSHED\tDUPLICATES")
+	for _, metric := range metrics {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", metric, s.Pushed[metric], s.Duplicates[metric])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dayServer answers every date with two hr readings during that day
+func dayServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		date := r.URL.Query().Get("date")
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start := day.Unix()
+		fmt.Fprintf(w, `{"status":200,"data":{"metrics":{%q:[{"type":"hr","object":{"title":"Heart Rate","day_start_timestamp":%d,"values":[{"value":60,"timestamp":%d},{"value":61,"timestamp":%d}]}}]}}}`,
+			date, start, start+300, start+600)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBackfillConcurrentFetchesWithStatus(t *testing.T) {
+	server := dayServer(t)
+	cfg := defaultConfig()
+	cfg.Date = "2024-01-10"
+	cfg.BackfillDays = 8
+	cfg.Concurrency = 4
+	fetcher := newFetcher(cfg, server.Client(), server.URL, Account{})
+	fetcher.stats = newPushStats()
+	status := newServer(cfg, []*Fetcher{fetcher}).Handler
+
+	// Poll /status while the workers fetch and the days are pushed
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				status.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/status", nil))
+			}
+		}
+	}()
+
+	exporter := &recordingExporter{}
+	summary := &backfillSummary{pushStats: fetcher.stats}
+	err := backfillAccount(cfg, fetcher, exporter, summary)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := exporter.samples()["ultrahuman_heart_rate_bpm"]
+	if len(got) != 2*cfg.BackfillDays {
+		t.Fatalf("pushed %d hr readings, want %d", len(got), 2*cfg.BackfillDays)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("readings pushed out of order: %v", got)
+		}
+	}
+	if last := time.Date(2024, 1, 10, 0, 10, 0, 0, time.UTC).Unix(); fetcher.LatestTimestamp() != last {
+		t.Errorf("LatestTimestamp = %d, want %d", fetcher.LatestTimestamp(), last)
+	}
+}
@@ -0,0 +1,840 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Config holds every runtime setting. Values are resolved with the precedence
+// explicit flags > environment variables > config file > defaults.
+type Config struct {
+	APIToken        string  `yaml:"api_token"`
+	APITokenFile    string  `yaml:"api_token_file"`
+	APITimeout      seconds `yaml:"api_timeout"`
+	APIURL          string  `yaml:"api_url"`         // overrides the endpoint URL, e.g. a local mock-server
+	Endpoint        string  `yaml:"endpoint"`        // partner API endpoint, see apiEndpoints
+	APIRateLimit    int     `yaml:"api_rate_limit"`  // requests per minute across all accounts; 0 is unlimited
+	APISinceParam   string  `yaml:"api_since_param"` // query parameter taking the newest fetched unix timestamp; unset fetches whole days
+	Strict          bool    `yaml:"strict"`          // unexpected API response shapes fail the fetch instead of being logged
+	ProxyURL        string  `yaml:"proxy_url"`
+	UserAgent       string  `yaml:"user_agent"`
+	AuthScheme      string  `yaml:"auth_scheme"`    // "none" sends the raw token; "Bearer" prefixes it
+	ListenAddress   string  `yaml:"listen_address"` // interface the main server binds to; empty is all
+	WebUsername     string  `yaml:"web_username"`   // basic auth required on the served endpoints except /health
+	WebPassword     string  `yaml:"web_password"`
+	TLSCertFile     string  `yaml:"tls_cert_file"` // serve HTTPS when set together with tls_key_file
+	TLSKeyFile      string  `yaml:"tls_key_file"`
+	Port            int     `yaml:"port"`
+	MetricsListen   string  `yaml:"metrics_listen"`   // separate address for /metrics and /metrics/readings
+	TelemetryListen string  `yaml:"telemetry_listen"` // separate address for the exporter's own metrics
+	Interval        seconds `yaml:"interval"`
+	IntervalJitter  float64 `yaml:"interval_jitter"`
+	Timezone        string  `yaml:"timezone"` // IANA zone whose calendar day is queried
+	Date            string  `yaml:"date"`     // day shown by the one-shot path and ending backfill: YYYY-MM-DD, today, yesterday or -Nd
+	Exporter        string  `yaml:"exporter"`
+	RemoteWriteURL  string  `yaml:"remote_write_url"`
+
+	RemoteWriteCAFile       string  `yaml:"remote_write_ca_file"`
+	RemoteWriteCertFile     string  `yaml:"remote_write_cert_file"`
+	RemoteWriteKeyFile      string  `yaml:"remote_write_key_file"`
+	RemoteWriteInsecure     bool    `yaml:"remote_write_insecure"`
+	RemoteWriteEncoding     string  `yaml:"remote_write_encoding"`
+	RemoteWriteFallbackURL  string  `yaml:"remote_write_fallback_url"` // secondary receiver for failover
+	RemoteWriteTimeout      seconds `yaml:"remote_write_timeout"`
+	RemoteWriteMaxIdleConns int     `yaml:"remote_write_max_idle_conns"` // keep-alive connections per host
+	RemoteWriteIdleTimeout  seconds `yaml:"remote_write_idle_timeout"`
+	RemoteWriteUsername     string  `yaml:"remote_write_username"` // basic auth for remote write
+	RemoteWritePassword     string  `yaml:"remote_write_password"`
+
+	GrafanaCloudURL   string `yaml:"grafana_cloud_url"`   // preset: stack remote write URL
+	GrafanaCloudUser  string `yaml:"grafana_cloud_user"`  // Prometheus instance ID of the stack
+	GrafanaCloudToken string `yaml:"grafana_cloud_token"` // access policy token with metrics:write
+
+	UnhealthyAfter seconds `yaml:"unhealthy_after"`
+	DownAfter      int     `yaml:"down_after"` // consecutive failed fetches before ultrahuman_up drops to 0
+	BatchSize      int     `yaml:"batch_size"`
+	DryRun         bool    `yaml:"dry_run"`
+	Quiet          bool    `yaml:"quiet"`       // suppress the routine per-cycle push log
+	ReplayFile     string  `yaml:"replay_file"` // saved API response served instead of the live API
+
+	RecordDir            string  `yaml:"record_dir"` // archive raw API responses fetched in serve mode
+	RecordGzip           bool    `yaml:"record_gzip"`
+	RecordRetention      seconds `yaml:"record_retention"`
+	PassthroughUnknown   bool    `yaml:"passthrough_unknown"`    // push unregistered metric types as ultrahuman_raw_<type>
+	MaxSamplesPerMetric  int     `yaml:"max_samples_per_metric"` // newest samples kept per metric each push; 0 is unlimited
+	MaxSampleAge         seconds `yaml:"max_sample_age"`         // samples older than this are dropped before pushing; 0 keeps all
+	KeepGoing            bool    `yaml:"keep_going"`             // write each metric type separately so one rejection doesn't block the rest
+	NoIndividualReadings bool    `yaml:"no_individual_readings"` // push only time series summaries; registry readings overrides per metric
+	SampleTimestampMode  string  `yaml:"sample_timestamp_mode"`  // "reading" keeps reading times; "ingest" stamps samples with the push time
+	Output               string  `yaml:"output"`
+	Pretty               bool    `yaml:"pretty"`   // indent JSON output
+	DumpRaw              bool    `yaml:"dump_raw"` // print raw API responses to stderr
+	Color                string  `yaml:"color"`    // auto, always or never
+	Compact              bool    `yaml:"compact"`  // one line per metric in the text report
+	Once                 bool    `yaml:"once"`
+	RemoteRead           bool    `yaml:"remote_read"`
+	SpoolDir             string  `yaml:"spool_dir"`
+	SpoolMaxBytes        int64   `yaml:"spool_max_bytes"`
+	BackfillDays         int     `yaml:"backfill_days"`
+	Concurrency          int     `yaml:"concurrency"`
+
+	PushgatewayURL      string `yaml:"pushgateway_url"`
+	PushgatewayJob      string `yaml:"pushgateway_job"`
+	PushgatewayInstance string `yaml:"pushgateway_instance"`
+
+	VictoriaMetricsURL string `yaml:"vm_url"`
+
+	OutFile     string `yaml:"out_file"`     // Prometheus text file written by the file exporter
+	TextfileDir string `yaml:"textfile_dir"` // node_exporter textfile collector directory, written every cycle
+
+	GraphiteAddress string `yaml:"graphite_address"`
+	GraphitePrefix  string `yaml:"graphite_prefix"`
+
+	Accounts []Account `yaml:"accounts"` // additional rings fetched by one process
+
+	AlertWebhookURL string      `yaml:"alert_webhook_url"`
+	Alerts          []AlertRule `yaml:"alerts"` // threshold rules evaluated in serve mode
+
+	Registry map[string]RegistryOverride `yaml:"registry"` // per-metric overrides of metricRegistry
+	Rename   map[string]string           `yaml:"rename"`   // series name rewrites, old -> new
+
+	MetricPrefix string `yaml:"metric_prefix"` // replaces "ultrahuman_" in exported series names
+
+	Labels  map[string]string `yaml:"labels"`  // static labels added to every pushed series
+	Include []string          `yaml:"include"` // metric keys to push (empty means all)
+	Exclude []string          `yaml:"exclude"` // metric keys never pushed
+
+	ShowVersion bool `yaml:"-"`
+	PrintConfig bool `yaml:"-"`
+}
+
+func defaultConfig() *Config {
+	hostname, _ := os.Hostname()
+	return &Config{
+		APITimeout:              30,
+		Endpoint:                "daily_metrics",
+		AuthScheme:              "none",
+		UserAgent:               defaultUserAgent(),
+		Port:                    8080,
+		Interval:                60,
+		Exporter:                "remote-write",
+		MetricPrefix:            builtinPrefix,
+		RemoteWriteEncoding:     "snappy",
+		Color:                   "auto",
+		DownAfter:               3,
+		SampleTimestampMode:     "reading",
+		RemoteWriteTimeout:      30,
+		RemoteWriteMaxIdleConns: 2,
+		RemoteWriteIdleTimeout:  90,
+		BatchSize:               defaultBatchSize,
+		SpoolMaxBytes:           100 << 20,
+		BackfillDays:            7,
+		Concurrency:             4,
+		PushgatewayJob:          "uh-ring",
+		PushgatewayInstance:     hostname,
+		GraphitePrefix:          "ultrahuman",
+	}
+}
+
+// loadConfig builds the Config from defaults, the optional --config file,
+// environment variables and finally the command line flags. It returns the
+// remaining non-flag arguments.
+func loadConfig(args []string) (*Config, []string, error) {
+	cfg := defaultConfig()
+
+	if path := configPathFromArgs(args); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, nil, err
+	}
+
+	// Flags default to the values resolved so far, so only explicitly set flags override them
+	flag.String("config", "", "Path to a YAML config file")
+	flag.StringVar(&cfg.APIToken, "api-token", cfg.APIToken, "API token for Ultrahuman")
+	flag.StringVar(&cfg.APITokenFile, "api-token-file", cfg.APITokenFile, "Read the API token from a file")
+	flag.Var(&cfg.APITimeout, "api-timeout", "Ultrahuman API request timeout (seconds or a duration like 1m)")
+	flag.StringVar(&cfg.APIURL, "api-url", cfg.APIURL, "API endpoint URL (default: the --endpoint's partner API URL)")
+	flag.StringVar(&cfg.Endpoint, "endpoint", cfg.Endpoint, "Partner API endpoint to fetch: daily_metrics")
+	flag.IntVar(&cfg.APIRateLimit, "api-rate-limit", cfg.APIRateLimit, "Maximum API requests per minute, shared by all accounts (0 = no limit)")
+	flag.StringVar(&cfg.APISinceParam, "api-since-param", cfg.APISinceParam, "Query parameter for fetching only readings newer than those already fetched")
+	flag.BoolVar(&cfg.Strict, "strict", cfg.Strict, "Fail fetches whose API response has an unexpected shape instead of logging it")
+	flag.StringVar(&cfg.ProxyURL, "proxy-url", cfg.ProxyURL, "Proxy URL for API and export requests (overrides HTTP(S)_PROXY)")
+	flag.StringVar(&cfg.AuthScheme, "auth-scheme", cfg.AuthScheme, "Authorization scheme for the API token: none or Bearer")
+	flag.StringVar(&cfg.UserAgent, "user-agent", cfg.UserAgent, "User-Agent for outgoing requests")
+	flag.StringVar(&cfg.ListenAddress, "listen-address", cfg.ListenAddress, "Interface to bind the server to, e.g. 127.0.0.1 (default all)")
+	flag.IntVar(&cfg.Port, "port", cfg.Port, "Port for Prometheus server")
+	flag.StringVar(&cfg.TLSCertFile, "tls-cert-file", cfg.TLSCertFile, "Certificate for serving the endpoints over HTTPS")
+	flag.StringVar(&cfg.TLSKeyFile, "tls-key-file", cfg.TLSKeyFile, "Private key for serving the endpoints over HTTPS")
+	flag.StringVar(&cfg.WebUsername, "web-username", cfg.WebUsername, "Basic auth username required on served endpoints (except /health)")
+	flag.StringVar(&cfg.WebPassword, "web-password", cfg.WebPassword, "Basic auth password required on served endpoints (except /health)")
+	flag.StringVar(&cfg.MetricsListen, "metrics-listen", cfg.MetricsListen, "Serve ring data /metrics on this address instead of --port (e.g. :9101)")
+	flag.StringVar(&cfg.TelemetryListen, "telemetry-listen", cfg.TelemetryListen, "Serve the exporter's own metrics on this address, separate from ring data")
+	flag.Var(&cfg.Interval, "interval", "Metric refresh interval (seconds or a duration like 5m)")
+	flag.StringVar(&cfg.Date, "date", cfg.Date, "Day to query: YYYY-MM-DD, today, yesterday or -Nd (default today)")
+	flag.StringVar(&cfg.Timezone, "timezone", cfg.Timezone, "IANA timezone whose day is fetched, e.g. America/Los_Angeles (default UTC)")
+	flag.Float64Var(&cfg.IntervalJitter, "interval-jitter", cfg.IntervalJitter, "Randomize each interval by +/- this fraction")
+	flag.StringVar(&cfg.RemoteWriteURL, "remote-write-url", cfg.RemoteWriteURL, "Prometheus remote write URL (e.g., http://localhost:9090/api/v1/write)")
+	flag.StringVar(&cfg.RemoteWriteCAFile, "remote-write-ca-file", cfg.RemoteWriteCAFile, "CA certificate to verify the remote write endpoint")
+	flag.StringVar(&cfg.RemoteWriteCertFile, "remote-write-cert-file", cfg.RemoteWriteCertFile, "Client certificate for remote write mutual TLS")
+	flag.StringVar(&cfg.RemoteWriteKeyFile, "remote-write-key-file", cfg.RemoteWriteKeyFile, "Client key for remote write mutual TLS")
+	flag.StringVar(&cfg.RemoteWriteFallbackURL, "remote-write-fallback-url", cfg.RemoteWriteFallbackURL, "Secondary remote write URL, used when the primary fails")
+	flag.Var(&cfg.RemoteWriteTimeout, "remote-write-timeout", "Remote write request timeout (seconds or a duration)")
+	flag.IntVar(&cfg.RemoteWriteMaxIdleConns, "remote-write-max-idle-conns", cfg.RemoteWriteMaxIdleConns, "Idle keep-alive connections kept per remote write host")
+	flag.Var(&cfg.RemoteWriteIdleTimeout, "remote-write-idle-timeout", "Time before an idle remote write connection is closed (seconds or a duration)")
+	flag.StringVar(&cfg.RemoteWriteEncoding, "remote-write-encoding", cfg.RemoteWriteEncoding, "Remote write compression: snappy or zstd")
+	flag.StringVar(&cfg.RemoteWriteUsername, "remote-write-username", cfg.RemoteWriteUsername, "Basic auth username for remote write")
+	flag.StringVar(&cfg.RemoteWritePassword, "remote-write-password", cfg.RemoteWritePassword, "Basic auth password for remote write")
+	flag.StringVar(&cfg.GrafanaCloudURL, "grafana-cloud-url", cfg.GrafanaCloudURL, "Grafana Cloud Prometheus remote write URL (sets up remote write with basic auth)")
+	flag.StringVar(&cfg.GrafanaCloudUser, "grafana-cloud-user", cfg.GrafanaCloudUser, "Grafana Cloud Prometheus instance ID")
+	flag.StringVar(&cfg.GrafanaCloudToken, "grafana-cloud-token", cfg.GrafanaCloudToken, "Grafana Cloud access policy token")
+	flag.BoolVar(&cfg.RemoteWriteInsecure, "remote-write-insecure", cfg.RemoteWriteInsecure, "Skip TLS verification of the remote write endpoint")
+	flag.StringVar(&cfg.Exporter, "exporter", cfg.Exporter, "Export backend: remote-write, pushgateway, victoriametrics, graphite, file or none")
+	flag.StringVar(&cfg.OutFile, "out-file", cfg.OutFile, "File the file exporter writes the latest metrics to, in Prometheus text format")
+	flag.StringVar(&cfg.TextfileDir, "textfile-dir", cfg.TextfileDir, "node_exporter textfile collector directory to write ultrahuman.prom into every cycle")
+	flag.StringVar(&cfg.VictoriaMetricsURL, "vm-url", cfg.VictoriaMetricsURL, "VictoriaMetrics base URL for the victoriametrics exporter (e.g., http://localhost:8428)")
+	flag.StringVar(&cfg.PushgatewayURL, "pushgateway-url", cfg.PushgatewayURL, "Pushgateway URL (e.g., http://localhost:9091)")
+	flag.StringVar(&cfg.PushgatewayJob, "pushgateway-job", cfg.PushgatewayJob, "Pushgateway job name")
+	flag.StringVar(&cfg.PushgatewayInstance, "pushgateway-instance", cfg.PushgatewayInstance, "Pushgateway instance label")
+	flag.Var(&accountsFlag{cfg: cfg}, "account", "Ring account as token=...,label=... (repeatable)")
+	flag.IntVar(&cfg.DownAfter, "down-after", cfg.DownAfter, "Consecutive failed fetches before ultrahuman_up reports 0")
+	flag.Var(&cfg.UnhealthyAfter, "unhealthy-after", "Time without a successful fetch before /ready fails (seconds or a duration; default 3x interval)")
+	flag.StringVar(&cfg.GraphiteAddress, "graphite-address", cfg.GraphiteAddress, "Carbon plaintext address (host:port)")
+	flag.StringVar(&cfg.GraphitePrefix, "graphite-prefix", cfg.GraphitePrefix, "Graphite metric path prefix")
+	flag.IntVar(&cfg.BatchSize, "batch-size", cfg.BatchSize, "Maximum series per remote write request")
+	flag.StringVar(&cfg.SpoolDir, "spool-dir", cfg.SpoolDir, "Directory to buffer failed pushes for later replay")
+	flag.Int64Var(&cfg.SpoolMaxBytes, "spool-max-bytes", cfg.SpoolMaxBytes, "Maximum spool size; oldest batches are dropped beyond it")
+	flag.IntVar(&cfg.BackfillDays, "days", cfg.BackfillDays, "Days to fetch with backfill")
+	flag.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "Parallel day fetches during backfill")
+	flag.BoolVar(&cfg.Once, "once", cfg.Once, "Fetch and push a single cycle, then exit")
+	flag.StringVar(&cfg.Output, "output", cfg.Output, "CLI output format: text or json")
+	flag.BoolVar(&cfg.Pretty, "pretty", cfg.Pretty, "Indent JSON output")
+	flag.BoolVar(&cfg.DumpRaw, "dump-raw", cfg.DumpRaw, "Print each raw API response body to stderr")
+	flag.BoolVar(&cfg.Compact, "compact", cfg.Compact, "Print one line per metric in the text report")
+	flag.StringVar(&cfg.Color, "color", cfg.Color, "Color the text report: auto, always or never")
+	flag.BoolVar(&cfg.RemoteRead, "remote-read", cfg.RemoteRead, "Serve recently pushed samples over the remote read protocol on /read")
+	flag.StringVar(&cfg.AlertWebhookURL, "alert-webhook-url", cfg.AlertWebhookURL, "URL to POST JSON alerts to when a threshold rule fires")
+	flag.StringVar(&cfg.MetricPrefix, "metric-prefix", cfg.MetricPrefix, "Prefix replacing ultrahuman_ in exported series names")
+	flag.BoolVar(&cfg.PassthroughUnknown, "passthrough-unknown", cfg.PassthroughUnknown, "Push metric types missing from the registry as ultrahuman_raw_<type>")
+	flag.IntVar(&cfg.MaxSamplesPerMetric, "max-samples-per-metric", cfg.MaxSamplesPerMetric, "Push at most this many of the newest samples per metric each cycle (0 = no limit)")
+	flag.Var(&cfg.MaxSampleAge, "max-sample-age", "Drop samples older than this before pushing (seconds or a duration like 1h; 0 keeps all)")
+	flag.BoolVar(&cfg.KeepGoing, "keep-going", cfg.KeepGoing, "Push each metric type separately and log individual failures")
+	flag.BoolVar(&cfg.NoIndividualReadings, "no-individual-readings", cfg.NoIndividualReadings, "Push only the summary (last/avg/total) of each time series metric")
+	flag.StringVar(&cfg.SampleTimestampMode, "sample-timestamp-mode", cfg.SampleTimestampMode, "Timestamp pushed samples with the reading time (reading) or the push time (ingest)")
+	flag.Var(failFastFlag{cfg}, "fail-fast", "Push all metrics in one request; any failure fails the cycle (default)")
+	flag.StringVar(&cfg.RecordDir, "record-dir", cfg.RecordDir, "Write each raw API response fetched in serve mode to this directory")
+	flag.BoolVar(&cfg.RecordGzip, "record-gzip", cfg.RecordGzip, "Gzip recorded responses")
+	flag.Var(&cfg.RecordRetention, "record-retention", "Delete recordings older than this (seconds or a duration like 720h; 0 keeps everything)")
+	flag.StringVar(&cfg.ReplayFile, "replay-file", cfg.ReplayFile, "Read API responses from this saved JSON file instead of the network")
+	flag.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "Log what would be pushed instead of sending it")
+	flag.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Suppress routine per-cycle logs; errors are still logged")
+	flag.BoolVar(&cfg.ShowVersion, "version", false, "Print version information and exit")
+	flag.BoolVar(&cfg.PrintConfig, "print-config", false, "Print the resolved configuration as JSON (secrets redacted) and exit")
+	flag.Usage = printUsage
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.Output != "" && cfg.Output != "text" && cfg.Output != "json" {
+		return nil, nil, fmt.Errorf("invalid --output %q (want text or json)", cfg.Output)
+	}
+	if cfg.Color != "auto" && cfg.Color != "always" && cfg.Color != "never" {
+		return nil, nil, fmt.Errorf("invalid --color %q (want auto, always or never)", cfg.Color)
+	}
+	if flagSet("keep-going") && flagSet("fail-fast") {
+		return nil, nil, fmt.Errorf("--keep-going and --fail-fast are mutually exclusive")
+	}
+	if cfg.Interval <= 0 {
+		return nil, nil, fmt.Errorf("invalid --interval %ds (must be positive)", cfg.Interval)
+	}
+	if cfg.SampleTimestampMode != "reading" && cfg.SampleTimestampMode != "ingest" {
+		return nil, nil, fmt.Errorf("invalid --sample-timestamp-mode %q (want reading or ingest)", cfg.SampleTimestampMode)
+	}
+	if cfg.ListenAddress != "" && net.ParseIP(cfg.ListenAddress) == nil {
+		if _, err := net.LookupHost(cfg.ListenAddress); err != nil {
+			return nil, nil, fmt.Errorf("invalid --listen-address %q: %w", cfg.ListenAddress, err)
+		}
+	}
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return nil, nil, fmt.Errorf("invalid --timezone %q: %w", cfg.Timezone, err)
+	}
+	if _, err := resolveDate(cfg.Date, time.Now().In(cfg.location())); err != nil {
+		return nil, nil, err
+	}
+	switch strings.ToLower(cfg.AuthScheme) {
+	case "", "none":
+		apiAuthScheme = ""
+	case "bearer":
+		apiAuthScheme = "Bearer"
+	default:
+		return nil, nil, fmt.Errorf("invalid --auth-scheme %q (want none or Bearer)", cfg.AuthScheme)
+	}
+	apiLimiter = newRateLimiter(cfg.APIRateLimit)
+	strictAPI = cfg.Strict
+	passthroughTypes = cfg.PassthroughUnknown
+	prettyJSON = cfg.Pretty
+	dumpRaw = cfg.DumpRaw
+	endpoint, err := lookupEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	activeEndpoint, apiURL = endpoint, endpoint.url
+	if cfg.APIURL != "" {
+		if _, err := url.ParseRequestURI(cfg.APIURL); err != nil {
+			return nil, nil, fmt.Errorf("invalid --api-url %q: %w", cfg.APIURL, err)
+		}
+		apiURL = cfg.APIURL
+	}
+	if err := cfg.applyGrafanaCloud(); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.validateAccounts(); err != nil {
+		return nil, nil, err
+	}
+	if cfg.NoIndividualReadings {
+		for key, config := range metricRegistry {
+			if config.MetricType == "timeseries" {
+				config.SummaryOnly = true
+				metricRegistry[key] = config
+			}
+		}
+	}
+	if err := applyRegistryOverrides(cfg.Registry); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.validateAlerts(); err != nil {
+		return nil, nil, err
+	}
+	if err := applyRenames(cfg.Rename); err != nil {
+		return nil, nil, err
+	}
+	if cfg.MetricPrefix != "" && !metricNamePattern.MatchString(cfg.MetricPrefix) {
+		return nil, nil, fmt.Errorf("invalid --metric-prefix %q", cfg.MetricPrefix)
+	}
+	metricPrefix = cfg.MetricPrefix
+
+	// Token precedence: --api-token > token file > env var / config file
+	if cfg.APITokenFile != "" && !flagSet("api-token") {
+		token, err := readTokenFile(cfg.APITokenFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.APIToken = token
+	}
+
+	return cfg, flag.Args(), nil
+}
+
+// redacted replaces secrets in a config copy so it can be shown
+const redacted = "<redacted>"
+
+// printConfig writes the resolved settings as JSON keyed like the config
+// file, with tokens and passwords redacted (--print-config)
+func (c *Config) printConfig(w io.Writer) error {
+	shown := *c
+	for _, secret := range []*string{&shown.APIToken, &shown.RemoteWritePassword, &shown.GrafanaCloudToken, &shown.WebPassword} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	shown.Accounts = make([]Account, len(c.Accounts))
+	for i, account := range c.Accounts {
+		account.Token = redacted
+		shown.Accounts[i] = account
+	}
+
+	// Round-trip through YAML so keys match the config file
+	data, err := yaml.Marshal(&shown)
+	if err != nil {
+		return err
+	}
+	var settings any
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ") // always: this output is only ever read by people
+	enc.SetEscapeHTML(false)
+	return enc.Encode(stringKeys(settings))
+}
+
+// stringKeys converts the map[any]any values decoded by yaml.v2 into
+// map[string]any so they can be encoded as JSON
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case []any:
+		for i, value := range v {
+			v[i] = stringKeys(value)
+		}
+	}
+	return v
+}
+
+// applyGrafanaCloud expands the --grafana-cloud-* preset into the generic
+// remote write settings: the push URL (adding /api/prom/push when only the
+// host is given) and basic auth with the instance ID and token. It refuses to
+// override another exporter or remote write URL.
+func (c *Config) applyGrafanaCloud() error {
+	if c.GrafanaCloudURL == "" {
+		return nil
+	}
+	if c.GrafanaCloudUser == "" || c.GrafanaCloudToken == "" {
+		return fmt.Errorf("--grafana-cloud-url needs --grafana-cloud-user (instance ID) and --grafana-cloud-token")
+	}
+	u, err := url.Parse(c.GrafanaCloudURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid --grafana-cloud-url %q", c.GrafanaCloudURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/api/prom/push"
+	}
+	if c.Exporter != "remote-write" {
+		return fmt.Errorf("--grafana-cloud-url uses remote write and can't be combined with --exporter %s", c.Exporter)
+	}
+	if c.RemoteWriteURL != "" {
+		return fmt.Errorf("--grafana-cloud-url sets the remote write URL and can't be combined with --remote-write-url")
+	}
+	c.RemoteWriteURL = u.String()
+	c.RemoteWriteUsername = c.GrafanaCloudUser
+	c.RemoteWritePassword = c.GrafanaCloudToken
+	return nil
+}
+
+// location returns the timezone used to pick the queried date (UTC when unset)
+func (c *Config) location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// today returns the current date in the configured timezone as YYYY-MM-DD
+func (c *Config) today() string {
+	return time.Now().In(c.location()).Format("2006-01-02")
+}
+
+// queryDate returns the --date day as YYYY-MM-DD, today when unset
+func (c *Config) queryDate() string {
+	date, err := resolveDate(c.Date, time.Now().In(c.location()))
+	if err != nil {
+		return c.today() // unreachable: loadConfig validated it
+	}
+	return date
+}
+
+// resolveDate turns a --date value into YYYY-MM-DD relative to now: a literal
+// date, "today", "yesterday" or "-Nd" for N days ago
+func resolveDate(value string, now time.Time) (string, error) {
+	switch value {
+	case "", "today":
+		return now.Format("2006-01-02"), nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1).Format("2006-01-02"), nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok && strings.HasPrefix(days, "-") {
+		if n, err := strconv.Atoi(days[1:]); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n).Format("2006-01-02"), nil
+		}
+	}
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		return "", fmt.Errorf("invalid --date %q (want YYYY-MM-DD, today, yesterday or -Nd)", value)
+	}
+	return value, nil
+}
+
+// logTimezone reports which day boundary fetches follow
+func (c *Config) logTimezone() {
+	if c.Timezone == "" {
+		log.Printf("Querying dates in UTC; set --timezone to follow your local day")
+		return
+	}
+	log.Printf("Querying dates in %s", c.Timezone)
+}
+
+// seconds is a setting in whole seconds, given either as a bare number
+// ("300") or as a Go duration ("5m") in flags, the environment and the
+// config file
+type seconds int
+
+func (s *seconds) String() string { return strconv.Itoa(int(*s)) }
+
+func (s *seconds) Set(value string) error {
+	if n, err := strconv.Atoi(value); err == nil {
+		*s = seconds(n)
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("want seconds or a duration like 5m")
+	}
+	if d%time.Second != 0 {
+		return fmt.Errorf("%s is not a whole number of seconds", value)
+	}
+	*s = seconds(d / time.Second)
+	return nil
+}
+
+func (s *seconds) UnmarshalYAML(unmarshal func(any) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return s.Set(value)
+}
+
+// envReference matches ${VAR} and the $$ escape in a config file value
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} in the string values of a config file with the
+// environment variable, so one file can serve several deployments; $$ stands
+// for a literal $. A bare $VAR is left alone so existing passwords containing
+// $ keep working. Referencing an unset variable is an error rather than an
+// empty value. The file is parsed first, so a reference in a comment is
+// ignored and YAML syntax in a variable can't change the file's structure.
+func expandEnv(data []byte) ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return data, nil // reported by the real parse
+	}
+	var missing []string
+	expanded, changed := expandEnvValue(doc, &missing)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("unset environment variables: %s", strings.Join(missing, ", "))
+	}
+	if !changed {
+		return data, nil // keep the original line numbers in parse errors
+	}
+	return yaml.Marshal(expanded)
+}
+
+// expandEnvValue expands the strings in a decoded YAML value and reports
+// whether any changed. A string that is a single reference to a number or a
+// boolean, e.g. port: ${PORT}, takes that type so it still fits its field.
+func expandEnvValue(value any, missing *[]string) (any, bool) {
+	switch v := value.(type) {
+	case map[any]any:
+		changed := false
+		for key, item := range v {
+			expanded, itemChanged := expandEnvValue(item, missing)
+			v[key] = expanded
+			changed = changed || itemChanged
+		}
+		return v, changed
+	case []any:
+		changed := false
+		for i, item := range v {
+			expanded, itemChanged := expandEnvValue(item, missing)
+			v[i] = expanded
+			changed = changed || itemChanged
+		}
+		return v, changed
+	case string:
+		expanded := envReference.ReplaceAllStringFunc(v, func(ref string) string {
+			if ref == "$$" {
+				return "$"
+			}
+			name := ref[2 : len(ref)-1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				*missing = append(*missing, name)
+			}
+			return value
+		})
+		if expanded == v {
+			return v, false
+		}
+		if envReference.FindString(v) == v && v != "$$" {
+			var scalar any
+			if yaml.Unmarshal([]byte(expanded), &scalar) == nil {
+				switch scalar.(type) {
+				case int, int64, uint64, float64, bool:
+					return scalar, true
+				}
+			}
+		}
+		return expanded, true
+	}
+	return value, false
+}
+
+// failFastFlag is the boolean --fail-fast, the inverse of --keep-going
+type failFastFlag struct{ cfg *Config }
+
+func (f failFastFlag) String() string {
+	if f.cfg == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!f.cfg.KeepGoing)
+}
+
+func (f failFastFlag) Set(value string) error {
+	failFast, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	f.cfg.KeepGoing = !failFast
+	return nil
+}
+
+func (f failFastFlag) IsBoolFlag() bool { return true }
+
+// flagSet reports whether the named flag was given explicitly on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading API token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("API token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// configPathFromArgs finds the --config value before the full flag set is parsed
+func configPathFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	data, err = expandEnv(data)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) applyEnv() error {
+	if v := os.Getenv("ULTRAHUMAN_API_TOKEN"); v != "" {
+		c.APIToken = v
+	}
+	if v := os.Getenv("ULTRAHUMAN_API_TOKEN_FILE"); v != "" {
+		c.APITokenFile = v
+	}
+	if v := os.Getenv("ULTRAHUMAN_REMOTE_WRITE_URL"); v != "" {
+		c.RemoteWriteURL = v
+	}
+	if v := os.Getenv("ULTRAHUMAN_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid ULTRAHUMAN_PORT %q: %w", v, err)
+		}
+		c.Port = port
+	}
+	if v := os.Getenv("ULTRAHUMAN_INTERVAL"); v != "" {
+		if err := c.Interval.Set(v); err != nil {
+			return fmt.Errorf("invalid ULTRAHUMAN_INTERVAL %q: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// RegistryOverride changes the settings of a built-in metric from the config file
+type RegistryOverride struct {
+	Range    *ValueRange `yaml:"range"`
+	Decimals *int        `yaml:"decimals"`
+	Smooth   *int        `yaml:"smooth"`   // moving-average window for a <name>_smoothed series
+	Readings *bool       `yaml:"readings"` // false pushes only the summary; true keeps readings despite --no-individual-readings
+}
+
+// applyRegistryOverrides updates metricRegistry before any fetch starts
+func applyRegistryOverrides(overrides map[string]RegistryOverride) error {
+	for key, override := range overrides {
+		config, ok := metricRegistry[key]
+		if !ok {
+			return fmt.Errorf("registry override for unknown metric %q", key)
+		}
+		if override.Range != nil {
+			if override.Range.Min > override.Range.Max {
+				return fmt.Errorf("registry override for %s: min %g is above max %g", key, override.Range.Min, override.Range.Max)
+			}
+			config.Range = override.Range
+		}
+		if override.Decimals != nil {
+			if *override.Decimals < 0 {
+				return fmt.Errorf("registry override for %s: decimals must not be negative", key)
+			}
+			config.Decimals = *override.Decimals
+		}
+		if override.Readings != nil {
+			if config.MetricType != "timeseries" {
+				return fmt.Errorf("registry override for %s: readings only applies to timeseries metrics", key)
+			}
+			config.SummaryOnly = !*override.Readings
+		}
+		if override.Smooth != nil {
+			if config.MetricType != "timeseries" || key == "steps" || *override.Smooth < 0 {
+				return fmt.Errorf("registry override for %s: smooth needs a non-negative window on a timeseries metric other than steps", key)
+			}
+			config.SmoothWindow = *override.Smooth
+		}
+		metricRegistry[key] = config
+	}
+	return nil
+}
+
+// Account is one ring's API token and the label value identifying its series
+type Account struct {
+	Token string `yaml:"token"`
+	Label string `yaml:"label"`
+}
+
+// metricNamePattern matches valid Prometheus metric names
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// applyRenames validates the rename mapping and installs it for buildTimeSeries
+func applyRenames(renames map[string]string) error {
+	targets := make(map[string]string, len(renames))
+	for from, to := range renames {
+		if !metricNamePattern.MatchString(to) {
+			return fmt.Errorf("rename %s: %q is not a valid Prometheus metric name", from, to)
+		}
+		if other, ok := targets[to]; ok {
+			return fmt.Errorf("rename: %s and %s both map to %s", other, from, to)
+		}
+		targets[to] = from
+	}
+	seriesRenames = renames
+	return nil
+}
+
+// validateAccounts requires every account to have a token and a unique label
+func (c *Config) validateAccounts() error {
+	seen := make(map[string]bool)
+	for i, account := range c.Accounts {
+		if account.Token == "" || account.Label == "" {
+			return fmt.Errorf("account %d needs both token and label", i+1)
+		}
+		if seen[account.Label] {
+			return fmt.Errorf("duplicate account label %q", account.Label)
+		}
+		seen[account.Label] = true
+	}
+	return nil
+}
+
+// accounts returns the configured accounts, or the single --api-token account
+func (c *Config) accounts() []Account {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+	return []Account{{Token: c.APIToken}}
+}
+
+// seriesLabels merges the static labels with the account label, if any
+func (a Account) seriesLabels(static map[string]string) map[string]string {
+	if a.Label == "" {
+		return static
+	}
+	return withLabel(static, "account", a.Label)
+}
+
+// accountsFlag parses repeated --account token=...,label=... flags. Accounts
+// given on the command line replace those from the config file.
+type accountsFlag struct {
+	cfg *Config
+	set bool
+}
+
+func (f *accountsFlag) String() string {
+	return ""
+}
+
+func (f *accountsFlag) Set(value string) error {
+	var account Account
+	for _, part := range strings.Split(value, ",") {
+		key, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid account %q: want token=...,label=...", value)
+		}
+		switch strings.TrimSpace(key) {
+		case "token":
+			account.Token = v
+		case "label":
+			account.Label = v
+		default:
+			return fmt.Errorf("invalid account field %q", key)
+		}
+	}
+	if account.Token == "" || account.Label == "" {
+		return fmt.Errorf("account %q needs both token and label", value)
+	}
+	if !f.set {
+		f.cfg.Accounts = nil
+		f.set = true
+	}
+	f.cfg.Accounts = append(f.cfg.Accounts, account)
+	return nil
+}
+
+// metricEnabled reports whether a metric key passes the include/exclude filters
+func (c *Config) metricEnabled(metricType string) bool {
+	for _, name := range c.Exclude {
+		if name == metricType {
+			return false
+		}
+	}
+	if len(c.Include) == 0 {
+		return true
+	}
+	for _, name := range c.Include {
+		if name == metricType {
+			return true
+		}
+	}
+	return false
+}
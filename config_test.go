@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, contents string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	return cfg, cfg.loadFile(path)
+}
+
+func TestLoadFileExpandsEnv(t *testing.T) {
+	t.Setenv("UH_TEST_PASSWORD", "a: b # not a comment")
+	t.Setenv("UH_TEST_PORT", "9090")
+	t.Setenv("UH_TEST_PREFIX", "uh")
+
+	cfg, err := loadTestConfig(t, `
+# the password used to be ${UH_TEST_UNSET}
+web_password: ${UH_TEST_PASSWORD}
+port: ${UH_TEST_PORT}
+metric_prefix: ${UH_TEST_PREFIX}_
+remote_write_password: pa$$word$x
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.WebPassword != "a: b # not a comment" {
+		t.Errorf("web_password = %q, want the variable verbatim", cfg.WebPassword)
+	}
+	if cfg.Port != 9090 {
+		t.Errorf("port = %d, want 9090", cfg.Port)
+	}
+	if cfg.MetricPrefix != "uh_" {
+		t.Errorf("metric_prefix = %q, want uh_", cfg.MetricPrefix)
+	}
+	if cfg.RemoteWritePassword != "pa$word$x" {
+		t.Errorf("remote_write_password = %q, want pa$word$x", cfg.RemoteWritePassword)
+	}
+}
+
+func TestLoadFileUnsetEnv(t *testing.T) {
+	_, err := loadTestConfig(t, "web_password: ${UH_TEST_UNSET}\n")
+	if err == nil || !strings.Contains(err.Error(), "UH_TEST_UNSET") {
+		t.Errorf("err = %v, want the unset variable named", err)
+	}
+}
+
+func TestLoadFileSecondsSettings(t *testing.T) {
+	cfg, err := loadTestConfig(t, "max_sample_age: 3600\nrecord_retention: 720h\ninterval: 5m\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxSampleAge != 3600 || cfg.RecordRetention != 720*3600 || cfg.Interval != 300 {
+		t.Errorf("got max_sample_age %d, record_retention %d, interval %d", cfg.MaxSampleAge, cfg.RecordRetention, cfg.Interval)
+	}
+}
+
+func TestGrafanaCloudPreset(t *testing.T) {
+	cfg, err := loadTestConfig(t, "grafana_cloud_url: https://prometheus-prod-01-eu-west-0.grafana.net\ngrafana_cloud_user: \"123456\"\ngrafana_cloud_token: glc_secret\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.applyGrafanaCloud(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RemoteWriteURL != "https://prometheus-prod-01-eu-west-0.grafana.net/api/prom/push" {
+		t.Errorf("remote write URL = %q, want the default push path appended", cfg.RemoteWriteURL)
+	}
+	if cfg.RemoteWriteUsername != "123456" || cfg.RemoteWritePassword != "glc_secret" {
+		t.Errorf("basic auth = %q/%q, want the instance ID and token", cfg.RemoteWriteUsername, cfg.RemoteWritePassword)
+	}
+	if cfg.Exporter != "remote-write" {
+		t.Errorf("exporter = %q, want remote-write", cfg.Exporter)
+	}
+
+	for name, conflict := range map[string]string{
+		"exporter":         "exporter: file\n",
+		"remote write URL": "remote_write_url: http://localhost:9090/api/v1/write\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, conflict+"grafana_cloud_url: https://prometheus-prod-01-eu-west-0.grafana.net/api/prom/push\ngrafana_cloud_user: \"123456\"\ngrafana_cloud_token: glc_secret\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.applyGrafanaCloud(); err == nil {
+				t.Errorf("preset accepted a conflicting %s; remote write URL %q, exporter %q", name, cfg.RemoteWriteURL, cfg.Exporter)
+			}
+		})
+	}
+}
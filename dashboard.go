@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ANSI sequences used by the dashboard and the colored report
+const (
+	ansiValue   = "\x1b[32m" // green
+	ansiClear   = "\x1b[H\x1b[2J"
+	ansiChanged = "\x1b[1;33m" // bold yellow
+	ansiDim     = "\x1b[2m"
+	ansiReset   = "\x1b[0m"
+)
+
+// dashboardRow is one metric line of the dashboard
+type dashboardRow struct {
+	label string
+	value string
+}
+
+// runDashboard implements the dashboard subcommand: it refetches every
+// --interval and redraws the latest value of each metric, highlighting the
+// ones that changed since the previous refresh. q or Ctrl-C quits.
+func runDashboard(cfg *Config, client *http.Client) int {
+	fetchers := newFetchers(cfg, client, apiURL)
+
+	quit := make(chan struct{}, 1)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		quit <- struct{}{}
+	}()
+
+	// Raw mode lets a single q quit without Enter; it also swallows Ctrl-C,
+	// so that is read as a key too
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer term.Restore(fd, state)
+		go readQuitKeys(os.Stdin, quit)
+	}
+
+	interval := time.Duration(cfg.Interval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous := make(map[string]string)
+	for {
+		var screen bytes.Buffer
+		previous = renderDashboard(&screen, cfg, fetchers, previous, interval)
+		// Raw mode doesn't translate \n into a carriage return
+		os.Stdout.WriteString(strings.ReplaceAll(screen.String(), "\n", "\r\n"))
+
+		select {
+		case <-quit:
+			fmt.Print(ansiClear)
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+// readQuitKeys signals quit on q, Q or Ctrl-C
+func readQuitKeys(r io.Reader, quit chan<- struct{}) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			return
+		}
+		switch buf[0] {
+		case 'q', 'Q', 0x03:
+			quit <- struct{}{}
+			return
+		}
+	}
+}
+
+// renderDashboard fetches every account and draws one screen. previous maps
+// account/label to the value shown last time; the new map is returned.
+func renderDashboard(w io.Writer, cfg *Config, fetchers []*Fetcher, previous map[string]string, interval time.Duration) map[string]string {
+	current := make(map[string]string)
+
+	fmt.Fprint(w, ansiClear)
+	fmt.Fprintln(w, "══════════════════════════════════════════════════════════")
+	fmt.Fprintf(w, "  ULTRAHUMAN DASHBOARD | %s\n", cfg.today())
+	fmt.Fprintln(w, "══════════════════════════════════════════════════════════")
+
+	for _, fetcher := range fetchers {
+		label := fetcher.account.Label
+		if label != "" {
+			fmt.Fprintf(w, "\n  %s\n", label)
+		}
+		resp, err := fetcher.Fetch(context.Background(), cfg.today())
+		if err != nil {
+			fmt.Fprintf(w, "\n  Fetch error: %v\n", err)
+			// Keep the last values so a failed refresh doesn't flag them as changed
+			for key, value := range previous {
+				if strings.HasPrefix(key, label+"/") {
+					current[key] = value
+				}
+			}
+			continue
+		}
+
+		rows := dashboardRows(resp)
+		width := 0
+		for _, row := range rows {
+			width = max(width, len(row.label))
+		}
+		fmt.Fprintln(w)
+		for _, row := range rows {
+			key := label + "/" + row.label
+			current[key] = row.value
+			value := row.value
+			if old, ok := previous[key]; ok && old != row.value {
+				value = ansiChanged + row.value + ansiReset
+			}
+			fmt.Fprintf(w, "  %-*s  %s\n", width, row.label, value)
+		}
+	}
+
+	fmt.Fprintln(w, "\n──────────────────────────────────────────────────────────")
+	fmt.Fprintf(w, "  %sUpdated %s · every %s · q to quit%s\n", ansiDim, time.Now().In(cfg.location()).Format("15:04:05"), interval, ansiReset)
+	return current
+}
+
+// dashboardRows lists the latest value of each registered metric in a
+// response, sorted by label
+func dashboardRows(resp *APIResponse) []dashboardRow {
+	var latestDate string
+	for date := range resp.Data.Metrics {
+		if date > latestDate {
+			latestDate = date
+		}
+	}
+
+	var rows []dashboardRow
+	seen := make(map[string]bool)
+	for _, m := range resp.Data.Metrics[latestDate] {
+		if m.Type == "sleep" {
+			var v SleepMetric
+			if err := json.Unmarshal(m.Object, &v); err == nil && v.Score != nil {
+				rows = append(rows, dashboardRow{label: "SLEEP", value: fmt.Sprintf("%.0f", *v.Score)})
+			}
+			continue
+		}
+		config, ok := metricRegistry[m.Type]
+		if !ok || seen[config.DisplayName] {
+			continue
+		}
+		value, ok := latestValue(m, config)
+		if !ok {
+			continue
+		}
+		seen[config.DisplayName] = true
+
+		formatted := config.formatWithUnit(value, config.Unit)
+		if config.IsDuration {
+			formatted = formatDuration(value)
+		}
+		rows = append(rows, dashboardRow{label: config.DisplayName, value: formatted})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].label < rows[j].label })
+	return rows
+}
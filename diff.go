@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// metricDiff is one row of the diff subcommand; nil values were missing that day
+type metricDiff struct {
+	Metric string   `json:"metric"`
+	From   *float64 `json:"from"`
+	To     *float64 `json:"to"`
+	Delta  *float64 `json:"delta"`
+}
+
+// runDiff implements "diff --from DATE --to DATE": it fetches both days and
+// prints how each simple daily metric changed. Returns the process exit code.
+func runDiff(client *http.Client, token string, args []string, output string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	from := fs.String("from", "", "First date (YYYY-MM-DD)")
+	to := fs.String("to", "", "Second date (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	for _, date := range []string{*from, *to} {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: diff needs --from and --to as YYYY-MM-DD")
+			return 2
+		}
+	}
+
+	fromMetrics, err := fetchDay(client, token, *from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching %s: %v\n", *from, err)
+		return 1
+	}
+	toMetrics, err := fetchDay(client, token, *to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching %s: %v\n", *to, err)
+		return 1
+	}
+
+	diffs := diffSimpleMetrics(fromMetrics, toMetrics)
+	if output == "json" {
+		newJSONEncoder(os.Stdout).Encode(diffs)
+		return 0
+	}
+	printDiffs(os.Stdout, diffs)
+	return 0
+}
+
+// fetchDay returns the metrics the API reports for one date
+func fetchDay(client *http.Client, token, date string) ([]Metric, error) {
+	resp, err := makeRequest(context.Background(), client, apiURL, activeEndpoint.params(date), token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("API error: %s", *resp.Error)
+	}
+	if metrics, ok := resp.Data.Metrics[date]; ok {
+		return metrics, nil
+	}
+	// Fall back to the only date in the response if it is keyed differently
+	if len(resp.Data.Metrics) == 1 {
+		for _, metrics := range resp.Data.Metrics {
+			return metrics, nil
+		}
+	}
+	return nil, nil
+}
+
+// simpleValue extracts a simple metric's value, or nil if it is absent
+func simpleValue(metrics []Metric, metricType string) *float64 {
+	for _, m := range metrics {
+		if m.Type != metricType {
+			continue
+		}
+		var v SimpleMetric
+		if err := json.Unmarshal(m.Object, &v); err == nil && v.Value != nil {
+			return v.Value
+		}
+	}
+	return nil
+}
+
+// diffSimpleMetrics compares every registered simple metric present on either day
+func diffSimpleMetrics(from, to []Metric) []metricDiff {
+	keys := make([]string, 0, len(metricRegistry))
+	for key, config := range metricRegistry {
+		if config.MetricType == "simple" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var diffs []metricDiff
+	for _, key := range keys {
+		d := metricDiff{Metric: key, From: simpleValue(from, key), To: simpleValue(to, key)}
+		if d.From == nil && d.To == nil {
+			continue
+		}
+		if d.From != nil && d.To != nil {
+			delta := *d.To - *d.From
+			d.Delta = &delta
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// printDiffs renders the diff table; missing values show as an em dash
+func printDiffs(w io.Writer, diffs []metricDiff) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tFROM\tTO\tDELTA")
+	for _, d := range diffs {
+		config := metricRegistry[d.Metric]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Metric, diffCell(config, d.From, false), diffCell(config, d.To, false), diffCell(config, d.Delta, true))
+	}
+	tw.Flush()
+}
+
+func diffCell(config MetricConfig, value *float64, signed bool) string {
+	if value == nil {
+		return "—"
+	}
+	sign := ""
+	if signed && *value > 0 {
+		sign = "+"
+	} else if signed && *value < 0 {
+		sign = "-"
+	}
+	v := *value
+	if signed {
+		v = math.Abs(v)
+	}
+	if config.IsDuration {
+		return sign + formatDuration(v)
+	}
+	return sign + config.formatValue(v)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// displayLine is one row of the text report: a section title, a labeled
+// field, or a single time series reading
+type displayLine struct {
+	section string
+	label   string
+	value   string
+	unit    string // suffix including its separator, see unitSuffix
+	at      string // reading time, for readings
+}
+
+// display buffers the report so field labels can be aligned across sections
+// before anything is written
+type display struct {
+	color   bool
+	compact bool // one line per section: its first field and newest reading time
+	lines   []displayLine
+}
+
+func (d *display) section(title string) {
+	d.lines = append(d.lines, displayLine{section: title})
+}
+
+func (d *display) field(label, value, unit string) {
+	d.lines = append(d.lines, displayLine{label: label, value: value, unit: unit})
+}
+
+func (d *display) reading(value, unit, at string) {
+	d.lines = append(d.lines, displayLine{value: value, unit: unit, at: at})
+}
+
+// flush writes the buffered lines with field values in one column
+func (d *display) flush(w io.Writer) {
+	if d.compact {
+		d.flushCompact(w)
+		return
+	}
+	width := 0
+	for _, line := range d.lines {
+		if line.label != "" {
+			width = max(width, len(line.label)+1)
+		}
+	}
+	for _, line := range d.lines {
+		switch {
+		case line.section != "":
+			fmt.Fprintf(w, "\n  %s\n", line.section)
+		case line.label != "":
+			fmt.Fprintf(w, "      %-*s %s\n", width, line.label+":", d.paint(line.value, line.unit))
+		default:
+			fmt.Fprintf(w, "      - %s @ %s\n", d.paint(line.value, line.unit), line.at)
+		}
+	}
+	d.lines = nil
+}
+
+// flushCompact writes each section as "TITLE: value (field @ time)", where
+// the time is that of the newest reading and is left out for daily values
+func (d *display) flushCompact(w io.Writer) {
+	for i := 0; i < len(d.lines); i++ {
+		title := d.lines[i].section
+		var first *displayLine
+		var at string
+		for i+1 < len(d.lines) && d.lines[i+1].section == "" {
+			i++
+			line := &d.lines[i]
+			if line.label != "" && first == nil {
+				first = line
+			}
+			if line.label == "" && line.at > at {
+				at = line.at
+			}
+		}
+		if first == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s", title, d.paint(first.value, first.unit))
+		if at != "" {
+			fmt.Fprintf(w, " (%s @ %s)", strings.ToLower(first.label), at)
+		}
+		fmt.Fprintln(w)
+	}
+	d.lines = nil
+}
+
+// paint renders a value and its unit, green and dim when color is on
+func (d *display) paint(value, unit string) string {
+	if !d.color {
+		return value + unit
+	}
+	out := ansiValue + value + ansiReset
+	if unit != "" {
+		out += ansiDim + unit + ansiReset
+	}
+	return out
+}
+
+// colorEnabled resolves --color for f: "always" and "never" are explicit,
+// "auto" colors only a terminal and honors NO_COLOR
+func (c *Config) colorEnabled(f *os.File) bool {
+	switch c.Color {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(int(f.Fd()))
+}
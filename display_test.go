@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestDisplayGolden(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "daily_metrics.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp APIResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		golden  string
+		color   bool
+		compact bool
+	}{
+		{"display.golden", false, false},
+		{"display_compact.golden", false, true},
+		{"display_color.golden", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			displayMetrics(&buf, &resp, tt.color, tt.compact)
+
+			path := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("output differs from %s (go test -update rewrites it):\n%s", path, buf.String())
+			}
+		})
+	}
+}
+
+func TestDisplayZeroValues(t *testing.T) {
+	resp := &APIResponse{Data: Data{Metrics: map[string][]Metric{"2024-01-01": {
+		{Type: "steps", Object: []byte(`{"title":"Steps","total":0,"avg":0,"values":[]}`)},
+		{Type: "active_minutes", Object: []byte(`{"value":0}`)},
+		{Type: "recovery", Object: []byte(`{"value":null}`)},
+	}}}}
+	var buf bytes.Buffer
+	displayMetrics(&buf, resp, false, true)
+	want := "ACTIVE MINUTES: 0 min\nSTEPS: 0\n"
+	if buf.String() != want {
+		t.Errorf("compact display of zero values:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	if got := getMetricValue(resp.Data.Metrics["2024-01-01"], "active_minutes"); got != "0" {
+		t.Errorf("active_minutes = %q, want 0", got)
+	}
+	if got := getMetricValue(resp.Data.Metrics["2024-01-01"], "recovery"); got != "null" {
+		t.Errorf("recovery = %q, want null", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// apiEndpoint describes one partner API endpoint: its default URL, how one
+// day is requested and how a response body decodes into an APIResponse.
+// Supporting another endpoint shape means adding an entry to apiEndpoints
+// with its own params and decode; fetching, pushing and display only see the
+// decoded APIResponse.
+type apiEndpoint struct {
+	url    string
+	params func(date string) map[string]string
+	decode func(body []byte) (*APIResponse, error)
+}
+
+// apiEndpoints maps --endpoint names to their endpoints
+var apiEndpoints = map[string]apiEndpoint{
+	// {"data": {"metrics": {"YYYY-MM-DD": [{"type": ..., "object": {...}}]},
+	// "latest_time_zone": ...}, "error": null, "status": 200}
+	"daily_metrics": {url: dailyMetricsURL, params: dateParams, decode: decodeEnvelope},
+}
+
+// activeEndpoint is the endpoint selected by --endpoint
+var activeEndpoint = apiEndpoints["daily_metrics"]
+
+// dateParams requests a single calendar day as ?date=YYYY-MM-DD
+func dateParams(date string) map[string]string {
+	return map[string]string{"date": date}
+}
+
+// decodeEnvelope decodes a body that already has the APIResponse shape
+func decodeEnvelope(body []byte) (*APIResponse, error) {
+	var resp APIResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// lookupEndpoint returns the endpoint named by --endpoint
+func lookupEndpoint(name string) (apiEndpoint, error) {
+	endpoint, ok := apiEndpoints[name]
+	if !ok {
+		names := make([]string, 0, len(apiEndpoints))
+		for name := range apiEndpoints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return apiEndpoint{}, fmt.Errorf("unknown --endpoint %q (want %s)", name, strings.Join(names, ", "))
+	}
+	return endpoint, nil
+}
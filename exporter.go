@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Exporter delivers the series built by pushMetrics to a backend
+type Exporter interface {
+	Write(timeseries []prompb.TimeSeries) error
+}
+
+// newExporter builds the exporter selected by --exporter, buffering failed
+// writes on disk when --spool-dir is set
+func newExporter(cfg *Config) (Exporter, error) {
+	if cfg.DryRun {
+		log.Printf("Dry run: series will be logged, not sent")
+		return dryRunExporter{}, nil
+	}
+
+	exporter, err := newBackendExporter(cfg)
+	if err != nil || cfg.SpoolDir == "" {
+		return exporter, err
+	}
+	log.Printf("Spooling failed writes to %s (max %d bytes)", cfg.SpoolDir, cfg.SpoolMaxBytes)
+	return newSpoolExporter(exporter, cfg.SpoolDir, cfg.SpoolMaxBytes)
+}
+
+func newBackendExporter(cfg *Config) (Exporter, error) {
+	proxy, err := proxyFunc(cfg.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Exporter {
+	case "", "remote-write":
+		if cfg.RemoteWriteURL == "" {
+			return nil, fmt.Errorf("--remote-write-url is required for the remote-write exporter")
+		}
+		tlsConfig, err := newClientTLSConfig(cfg.RemoteWriteCAFile, cfg.RemoteWriteCertFile, cfg.RemoteWriteKeyFile, cfg.RemoteWriteInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("remote write TLS: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		transport.TLSClientConfig = tlsConfig
+		transport.MaxIdleConnsPerHost = cfg.RemoteWriteMaxIdleConns
+		transport.IdleConnTimeout = time.Duration(cfg.RemoteWriteIdleTimeout) * time.Second
+		rwClient := NewRemoteWriteClient(cfg.RemoteWriteURL, &userAgentTransport{next: transport, userAgent: cfg.UserAgent})
+		rwClient.batchSize = cfg.BatchSize
+		rwClient.fallbackURL = cfg.RemoteWriteFallbackURL
+		rwClient.client.Timeout = time.Duration(cfg.RemoteWriteTimeout) * time.Second
+		rwClient.username, rwClient.password = cfg.RemoteWriteUsername, cfg.RemoteWritePassword
+		if err := rwClient.setEncoding(cfg.RemoteWriteEncoding); err != nil {
+			return nil, err
+		}
+		log.Printf("Remote write target: %s", cfg.RemoteWriteURL)
+		if cfg.RemoteWriteFallbackURL != "" {
+			log.Printf("Remote write fallback: %s", cfg.RemoteWriteFallbackURL)
+		}
+		return rwClient, nil
+	case "pushgateway":
+		if cfg.PushgatewayURL == "" {
+			return nil, fmt.Errorf("--pushgateway-url is required for the pushgateway exporter")
+		}
+		log.Printf("Pushgateway target: %s (job=%s, instance=%s)", cfg.PushgatewayURL, cfg.PushgatewayJob, cfg.PushgatewayInstance)
+		pgClient := NewPushgatewayClient(cfg.PushgatewayURL, cfg.PushgatewayJob, cfg.PushgatewayInstance, cfg.Labels)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		pgClient.client.Transport = &userAgentTransport{next: transport, userAgent: cfg.UserAgent}
+		return pgClient, nil
+	case "victoriametrics":
+		if cfg.VictoriaMetricsURL == "" {
+			return nil, fmt.Errorf("--vm-url is required for the victoriametrics exporter")
+		}
+		vmClient := NewVictoriaMetricsClient(cfg.VictoriaMetricsURL)
+		log.Printf("VictoriaMetrics import target: %s", vmClient.url)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		vmClient.client.Transport = &userAgentTransport{next: transport, userAgent: cfg.UserAgent}
+		return vmClient, nil
+	case "none":
+		log.Printf("No push exporter; data is only served on the pull endpoints")
+		return noopExporter{}, nil
+	case "graphite":
+		if cfg.GraphiteAddress == "" {
+			return nil, fmt.Errorf("--graphite-address is required for the graphite exporter")
+		}
+		log.Printf("Graphite target: %s (prefix=%s)", cfg.GraphiteAddress, cfg.GraphitePrefix)
+		return NewGraphiteClient(cfg.GraphiteAddress, cfg.GraphitePrefix), nil
+	case "file":
+		if cfg.OutFile == "" {
+			return nil, fmt.Errorf("--out-file is required for the file exporter")
+		}
+		log.Printf("Writing latest metrics to %s", cfg.OutFile)
+		return NewFileExporter(cfg, cfg.OutFile), nil
+	default:
+		return nil, fmt.Errorf("unknown exporter %q (want remote-write, pushgateway, victoriametrics, graphite, file or none)", cfg.Exporter)
+	}
+}
+
+// noopExporter discards writes, for pull-only deployments
+type noopExporter struct{}
+
+func (noopExporter) Write([]prompb.TimeSeries) error { return nil }
+
+// dryRunExporter logs each series name, sample value, and timestamp instead of sending it
+type dryRunExporter struct{}
+
+func (dryRunExporter) Write(timeseries []prompb.TimeSeries) error {
+	for _, ts := range timeseries {
+		name := seriesName(ts)
+		for _, sample := range ts.Samples {
+			log.Printf("[dry-run] %s %g @ %s", name, sample.Value, time.UnixMilli(sample.Timestamp).UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// seriesName returns the __name__ label of a series
+func seriesName(ts prompb.TimeSeries) string {
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Fetcher fetches one account's daily metrics and pushes the readings it
+// hasn't pushed before. It owns the dedup state, so independent fetchers
+// (one per account, or one per test) never share it.
+type Fetcher struct {
+	cfg     *Config
+	client  *http.Client
+	baseURL string
+	account Account
+
+	mu          sync.Mutex
+	lastPushed  map[string]int64        // metric type -> newest pushed reading
+	lastSimple  map[string]simpleSample // simple metric type -> last pushed value
+	unknownSeen map[string]bool         // unregistered metric types already logged
+
+	// latest is the newest reading seen across all metrics. It is atomic so
+	// /status can read it without waiting on f.mu while a batch is built.
+	latest atomic.Int64
+
+	stats *pushStats // optional per-run counters, used by backfill
+
+	dayMu sync.Mutex
+	day   *fetchedDay // last day fetched, for merging --api-since-param responses
+}
+
+// fetchedDay is the full day of metrics a Fetcher last fetched. Partial
+// --api-since-param responses for the same date are merged into it, so the
+// day-level series (steps total, reading count, gap, smoothing) still see the
+// whole day.
+type fetchedDay struct {
+	date    string
+	metrics []Metric
+}
+
+// pushStats counts samples per metric type across pushes
+type pushStats struct {
+	Pushed     map[string]int `json:"pushed"`
+	Duplicates map[string]int `json:"duplicates"` // readings skipped as already pushed
+}
+
+func newPushStats() *pushStats {
+	return &pushStats{Pushed: make(map[string]int), Duplicates: make(map[string]int)}
+}
+
+func (s *pushStats) duplicate(metric string) {
+	if s != nil {
+		s.Duplicates[metric]++
+	}
+}
+
+// pushed records the samples of successfully written groups
+func (s *pushStats) pushed(timeseries []prompb.TimeSeries, groups []seriesGroup) {
+	if s == nil {
+		return
+	}
+	for i, group := range groups {
+		end := len(timeseries)
+		if i+1 < len(groups) {
+			end = groups[i+1].start
+		}
+		for _, ts := range timeseries[group.start:end] {
+			s.Pushed[group.metric] += len(ts.Samples)
+		}
+	}
+}
+
+func newFetcher(cfg *Config, client *http.Client, baseURL string, account Account) *Fetcher {
+	return &Fetcher{
+		cfg:         cfg,
+		client:      client,
+		baseURL:     baseURL,
+		account:     account,
+		lastPushed:  make(map[string]int64),
+		lastSimple:  make(map[string]simpleSample),
+		unknownSeen: make(map[string]bool),
+	}
+}
+
+// newFetchers returns a Fetcher for every configured account
+func newFetchers(cfg *Config, client *http.Client, baseURL string) []*Fetcher {
+	var fetchers []*Fetcher
+	for _, account := range cfg.accounts() {
+		fetchers = append(fetchers, newFetcher(cfg, client, baseURL, account))
+	}
+	return fetchers
+}
+
+// Fetch requests the metrics for date (YYYY-MM-DD). An error in the API
+// envelope is returned as an error.
+func (f *Fetcher) Fetch(ctx context.Context, date string) (*APIResponse, error) {
+	params := activeEndpoint.params(date)
+	// Only ask for readings newer than those already fetched for the day, when
+	// the API has been configured with a parameter for it (--api-since-param)
+	name := f.cfg.APISinceParam
+	var since int64
+	if name != "" {
+		if since = f.since(date); since > 0 {
+			params[name] = strconv.FormatInt(since, 10)
+		}
+	}
+	resp, err := makeRequest(ctx, f.client, f.baseURL, params, f.account.Token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("API error: %s", *resp.Error)
+	}
+	if name != "" {
+		f.mergeDay(date, resp, since > 0)
+	}
+	return resp, nil
+}
+
+// since returns the lower bound to fetch date from: the oldest of the newest
+// readings per time series metric already fetched for it, so no metric misses
+// readings. It is 0, for a full fetch, when date isn't the day last fetched.
+func (f *Fetcher) since(date string) int64 {
+	f.dayMu.Lock()
+	defer f.dayMu.Unlock()
+	if f.day == nil || f.day.date != date {
+		return 0
+	}
+	var since int64
+	for _, m := range f.day.metrics {
+		var v TimeSeriesMetric
+		if json.Unmarshal(m.Object, &v) != nil {
+			continue
+		}
+		if latest := getLatestTimestamp(v.Values); latest > 0 && (since == 0 || latest < since) {
+			since = latest
+		}
+	}
+	return since
+}
+
+// mergeDay replaces the metrics of a partial response with the day fetched so
+// far plus the new readings, and keeps the result for the next fetch
+func (f *Fetcher) mergeDay(date string, resp *APIResponse, partial bool) {
+	f.dayMu.Lock()
+	defer f.dayMu.Unlock()
+	metrics := resp.Data.Metrics[date]
+	if partial && f.day != nil && f.day.date == date {
+		metrics = mergeMetrics(f.day.metrics, metrics)
+		if resp.Data.Metrics == nil {
+			resp.Data.Metrics = make(map[string][]Metric)
+		}
+		resp.Data.Metrics[date] = metrics
+	}
+	f.day = &fetchedDay{date: date, metrics: metrics}
+}
+
+// mergeMetrics merges a partial response into a day's metrics. Readings are
+// combined by timestamp; every other field, such as the day summaries, is
+// taken from the newer response. Metrics the partial response lacks are kept.
+func mergeMetrics(day, partial []Metric) []Metric {
+	merged := append([]Metric(nil), day...)
+	index := make(map[string]int) // metric type -> position in merged
+	for i, m := range merged {
+		index[m.Type] = i
+	}
+	for _, m := range partial {
+		i, ok := index[m.Type]
+		if !ok {
+			index[m.Type] = len(merged)
+			merged = append(merged, m)
+			continue
+		}
+		merged[i] = mergeMetric(merged[i], m)
+	}
+	return merged
+}
+
+func mergeMetric(old, m Metric) Metric {
+	var oldFields, fields map[string]json.RawMessage
+	if json.Unmarshal(old.Object, &oldFields) != nil || json.Unmarshal(m.Object, &fields) != nil || fields == nil {
+		return m
+	}
+	if oldFields["values"] == nil && fields["values"] == nil {
+		return m // a simple metric: the newer value replaces the old one
+	}
+	byTimestamp := make(map[int64]TimeValue)
+	for _, raw := range []json.RawMessage{oldFields["values"], fields["values"]} {
+		var values []TimeValue
+		if raw != nil && json.Unmarshal(raw, &values) != nil {
+			return m
+		}
+		for _, reading := range values {
+			byTimestamp[reading.Timestamp] = reading
+		}
+	}
+	combined := make([]TimeValue, 0, len(byTimestamp))
+	for _, reading := range byTimestamp {
+		combined = append(combined, reading)
+	}
+	sort.Slice(combined, func(i, j int) bool { return combined[i].Timestamp < combined[j].Timestamp })
+
+	var err error
+	if fields["values"], err = json.Marshal(combined); err != nil {
+		return m
+	}
+	object, err := json.Marshal(fields)
+	if err != nil {
+		return m
+	}
+	return Metric{Type: m.Type, Object: object}
+}
+
+// Push pushes every date in a response, oldest first
+func (f *Fetcher) Push(resp *APIResponse, exporter Exporter) error {
+	for _, date := range resp.Data.sortedDates() {
+		if err := f.pushMetrics(resp.Data.Metrics[date], exporter); err != nil {
+			return fmt.Errorf("push metrics for %s: %w", date, err)
+		}
+	}
+	return nil
+}
+
+// simpleSample is the last pushed value of a simple daily metric
+type simpleSample struct {
+	value    float64
+	dayStart int64
+}
+
+// simpleChanged reports whether a simple daily metric differs from the last
+// push, by value or by day, and records it in update if so. Daily summaries
+// don't change during the day, so this keeps them from being re-sent every
+// cycle. Values from a day older than the last pushed one are skipped: the
+// series already moved on to the newer day, and a multi-day response would
+// otherwise flip the state back and forth every cycle. Callers must hold f.mu.
+func (f *Fetcher) simpleChanged(update *dedupUpdate, metricType string, value float64, dayStart int64) bool {
+	last, seen := f.lastSimple[metricType]
+	if seen && (dayStart < last.dayStart || last.value == value && last.dayStart == dayStart) {
+		return false
+	}
+	update.lastSimple[metricType] = simpleSample{value: value, dayStart: dayStart}
+	return true
+}
+
+// simpleTimestamp picks the timestamp for a changed simple metric: the start
+// of its day, or now if that day was already pushed with another value, since
+// receivers reject a second sample at the same timestamp. Callers must hold f.mu.
+func (f *Fetcher) simpleTimestamp(update *dedupUpdate, metricType string, dayStart int64) int64 {
+	ts := dayStart
+	if last := f.lastPushed[metricType]; last >= dayStart {
+		ts = max(time.Now().Unix(), last+1)
+	}
+	update.lastPushed[metricType] = ts
+	return ts
+}
+
+// dedupUpdate is the dedup state a batch moves to once it is written. It is
+// applied only after a successful write, so readings of a failed write are
+// retried in full on the next cycle.
+type dedupUpdate struct {
+	lastPushed map[string]int64
+	lastSimple map[string]simpleSample
+	latest     map[string]int64 // newest reading per metric type, for f.latest
+}
+
+func newDedupUpdate() *dedupUpdate {
+	return &dedupUpdate{
+		lastPushed: make(map[string]int64),
+		lastSimple: make(map[string]simpleSample),
+		latest:     make(map[string]int64),
+	}
+}
+
+// pushed marks the readings of metricType up to ts as pushed
+func (u *dedupUpdate) pushed(metricType string, ts int64) {
+	u.lastPushed[metricType] = max(u.lastPushed[metricType], ts)
+	u.latest[metricType] = max(u.latest[metricType], ts)
+}
+
+// commit applies update to the dedup state, except for the metric types in
+// failed, whose writes were rejected
+func (f *Fetcher) commit(update *dedupUpdate, failed map[string]bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for metricType, ts := range update.lastPushed {
+		if !failed[metricType] {
+			f.lastPushed[metricType] = max(f.lastPushed[metricType], ts)
+		}
+	}
+	for metricType, sample := range update.lastSimple {
+		if !failed[metricType] {
+			f.lastSimple[metricType] = sample
+		}
+	}
+	for metricType, ts := range update.latest {
+		if !failed[metricType] {
+			f.advanceLatest(ts)
+		}
+	}
+}
+
+// passthroughConfig derives a registry entry for a metric type the registry
+// doesn't know, from the shape of its object, so it is pushed as
+// ultrahuman_raw_<type>. New types are logged once. Callers must hold f.mu.
+func (f *Fetcher) passthroughConfig(m Metric) (MetricConfig, bool) {
+	config := MetricConfig{DisplayName: m.Type, PrometheusName: builtinPrefix + "raw_" + sanitizeMetricName(m.Type)}
+
+	var ts TimeSeriesMetric
+	var simple SimpleMetric
+	switch {
+	case json.Unmarshal(m.Object, &ts) == nil && len(ts.Values) > 0:
+		config.MetricType = "timeseries"
+	case json.Unmarshal(m.Object, &simple) == nil && simple.Value != nil:
+		config.MetricType = "simple"
+	default:
+		return MetricConfig{}, false
+	}
+
+	if !f.unknownSeen[m.Type] {
+		f.unknownSeen[m.Type] = true
+		log.Printf("Unknown %s metric %q pushed as %s; consider adding a registry entry", config.MetricType, m.Type, config.PrometheusName)
+	}
+	return config, true
+}
+
+// sanitizeMetricName replaces characters not allowed in metric names with _
+func sanitizeMetricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// LatestTimestamp returns the newest reading timestamp seen, for /status
+func (f *Fetcher) LatestTimestamp() int64 {
+	return f.latest.Load()
+}
+
+// advanceLatest raises the newest reading timestamp to ts if it is newer
+func (f *Fetcher) advanceLatest(ts int64) {
+	for {
+		current := f.latest.Load()
+		if ts <= current || f.latest.CompareAndSwap(current, ts) {
+			return
+		}
+	}
+}
+
+// pushMetrics pushes one date's time series metrics through the exporter with
+// their original timestamps, plus the simple daily metrics that changed.
+// f.mu is held only while the batch is built, not during the write, so a slow
+// receiver doesn't block /status or other pushes on the dedup state.
+func (f *Fetcher) pushMetrics(metrics []Metric, exporter Exporter) error {
+	cfg := f.cfg
+	if exporter == nil {
+		return nil
+	}
+
+	timeseries, groups, duplicates, update := f.collectSeries(metrics)
+
+	if cfg.MaxSampleAge > 0 {
+		timeseries, groups = dropOldGroups(timeseries, groups, time.Now().Add(-time.Duration(cfg.MaxSampleAge)*time.Second).UnixMilli())
+	}
+	if cfg.MaxSamplesPerMetric > 0 {
+		timeseries, groups = capGroups(timeseries, groups, cfg.MaxSamplesPerMetric)
+	}
+	if cfg.SampleTimestampMode == "ingest" {
+		timeseries, groups = ingestGroups(timeseries, groups, time.Now().UnixMilli())
+	}
+	if len(timeseries) == 0 {
+		// Nothing left to write, e.g. only out-of-range readings
+		f.commit(update, nil)
+		pushCounters.observe(0, duplicates, false)
+		return nil
+	}
+
+	if !cfg.Quiet {
+		log.Printf("Pushing %d data points", len(timeseries))
+	}
+	var err error
+	var failed map[string]bool
+	if cfg.KeepGoing {
+		failed, err = writeGroups(exporter, timeseries, groups)
+	} else if err = exporter.Write(timeseries); err != nil {
+		failed = make(map[string]bool, len(groups))
+		for _, group := range groups {
+			failed[group.metric] = true
+		}
+	}
+	f.commit(update, failed)
+
+	written, writtenGroups := timeseries, groups
+	if len(failed) > 0 {
+		written, writtenGroups = dropFailedGroups(timeseries, groups, failed)
+	}
+	if len(written) > 0 {
+		if cfg.RemoteRead {
+			recentSeries.add(written)
+		}
+		pendingReadings.add(written)
+		readingEvents.publish(readingEventsFor(written))
+		observeAlerts(f.account.Label, written, writtenGroups)
+		f.mu.Lock()
+		f.stats.pushed(written, writtenGroups)
+		f.mu.Unlock()
+	}
+	pushCounters.observe(len(timeseries), duplicates, err == nil)
+	alerts.flush()
+	return err
+}
+
+// collectSeries builds the series of one date not pushed before, grouped by
+// metric type, and the dedup update that marks them pushed. It also returns
+// how many readings were skipped as duplicates.
+func (f *Fetcher) collectSeries(metrics []Metric) ([]prompb.TimeSeries, []seriesGroup, int, *dedupUpdate) {
+	cfg, account := f.cfg, f.account
+	var timeseries []prompb.TimeSeries
+	update := newDedupUpdate()
+	labels := account.seriesLabels(cfg.Labels)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pushedSimple := make(map[string]bool) // some metric types share a series name
+	duplicates := 0
+	var groups []seriesGroup
+	for _, m := range metrics {
+		groups = append(groups, seriesGroup{metric: m.Type, start: len(timeseries)})
+		config, ok := metricRegistry[m.Type]
+		if !ok && cfg.PassthroughUnknown && m.Type != "sleep" {
+			config, ok = f.passthroughConfig(m)
+		}
+		if !ok || config.PrometheusName == "" || !cfg.metricEnabled(m.Type) {
+			continue
+		}
+		groups[len(groups)-1].reading = exportedName(config.PrometheusName)
+
+		// Simple daily metrics carry one value, stamped at the start of its day
+		if config.MetricType == "simple" {
+			var v SimpleMetric
+			if err := json.Unmarshal(m.Object, &v); err != nil || v.Value == nil || v.DayStartTimestamp == 0 {
+				continue
+			}
+			if pushedSimple[config.PrometheusName] {
+				continue
+			}
+			if !f.simpleChanged(update, m.Type, *v.Value, v.DayStartTimestamp) {
+				f.stats.duplicate(m.Type)
+				duplicates++
+				continue
+			}
+			pushedSimple[config.PrometheusName] = true
+			ts := f.simpleTimestamp(update, m.Type, v.DayStartTimestamp)
+			timeseries = append(timeseries, buildTimeSeries(config.PrometheusName, *v.Value, ts*1000, labels))
+			continue
+		}
+		if config.MetricType != "timeseries" {
+			continue
+		}
+
+		var v TimeSeriesMetric
+		if err := json.Unmarshal(m.Object, &v); err != nil {
+			continue
+		}
+		// The API may return readings out of order; push them oldest first so
+		// the dedup timestamp and receivers both see a monotonic series
+		sort.SliceStable(v.Values, func(i, j int) bool { return v.Values[i].Timestamp < v.Values[j].Timestamp })
+
+		lastTs := f.lastPushed[m.Type]
+
+		// Largest gap between readings so far today, stamped at the newest reading
+		if latest := getLatestTimestamp(v.Values); latest > lastTs && len(v.Values) > 1 {
+			gapLabels := withLabel(labels, "metric", m.Type)
+			timeseries = append(timeseries, buildTimeSeries("ultrahuman_reading_gap_seconds", float64(largestGap(v.Values)), latest*1000, gapLabels))
+		}
+
+		// Readings captured so far today. It grows during the day, so it is
+		// stamped at the newest reading rather than the day start, where a
+		// changed value would be rejected; a day without readings reports 0 there
+		countLabels := withLabel(labels, "metric", m.Type)
+		if latest := getLatestTimestamp(v.Values); latest > lastTs {
+			timeseries = append(timeseries, buildTimeSeries("ultrahuman_reading_count", float64(len(v.Values)), latest*1000, countLabels))
+		} else if len(v.Values) == 0 && v.present() && v.DayStartTimestamp > lastTs {
+			timeseries = append(timeseries, buildTimeSeries("ultrahuman_reading_count", 0, v.DayStartTimestamp*1000, countLabels))
+		}
+
+		// Summary only: one sample of the Field value at the newest reading
+		if config.SummaryOnly {
+			ts := getLatestTimestamp(v.Values)
+			if ts == 0 && v.present() {
+				ts = v.DayStartTimestamp
+			}
+			if ts == 0 || ts <= lastTs {
+				if ts != 0 {
+					f.stats.duplicate(m.Type)
+					duplicates++
+				}
+				continue
+			}
+			value := v.summary(config.Field)
+			timeseries = append(timeseries, buildTimeSeries(config.PrometheusName, value, ts*1000, labels))
+			update.pushed(m.Type, ts)
+			continue
+		}
+
+		// Steps: push the intraday running total at each reading so the series
+		// behaves as a counter that resets at the start of each day
+		if m.Type == "steps" {
+			// A day with no step readings (e.g. a recovery day) still reports
+			// its total; push that at the start of the day so 0 isn't lost
+			if len(v.Values) == 0 && v.present() && v.DayStartTimestamp > lastTs {
+				timeseries = append(timeseries, buildTimeSeries(config.PrometheusName, v.Total, v.DayStartTimestamp*1000, labels))
+				update.pushed(m.Type, v.DayStartTimestamp)
+				continue
+			}
+			var runningTotal float64
+			for _, reading := range v.Values {
+				runningTotal += reading.Value
+				if reading.Timestamp <= lastTs {
+					f.stats.duplicate(m.Type)
+					duplicates++
+					continue
+				}
+				timeseries = append(timeseries, buildTimeSeries(config.PrometheusName, runningTotal, reading.Timestamp*1000, labels))
+				update.pushed(m.Type, reading.Timestamp)
+			}
+			continue
+		}
+
+		// Push each individual reading with its timestamp. This covers the whole
+		// curve for hr, hrv, temp, spo2 and glucose regardless of the display Field.
+		// With a smoothing window, a <name>_smoothed moving average is pushed
+		// alongside; the window also covers earlier readings so it starts warm.
+		var window []float64
+		for _, reading := range v.Values {
+			inRange := config.inRange(reading.Value)
+			if inRange && config.SmoothWindow > 0 {
+				window = append(window, reading.Value)
+				if len(window) > config.SmoothWindow {
+					window = window[1:]
+				}
+			}
+			if reading.Timestamp <= lastTs {
+				f.stats.duplicate(m.Type)
+				duplicates++
+				continue
+			}
+			// Out-of-range readings are sensor glitches; mark them seen but don't push
+			if !inRange {
+				log.Printf("Dropping out-of-range %s reading %g @ %d", m.Type, reading.Value, reading.Timestamp)
+			} else {
+				timestampMs := reading.Timestamp * 1000
+				timeseries = append(timeseries, buildTimeSeries(config.PrometheusName, reading.Value, timestampMs, labels))
+				if config.SmoothWindow > 0 {
+					timeseries = append(timeseries, buildTimeSeries(config.PrometheusName+"_smoothed", mean(window), timestampMs, labels))
+				}
+			}
+			update.pushed(m.Type, reading.Timestamp)
+		}
+	}
+	return timeseries, groups, duplicates, update
+}
+
+// seriesGroup marks where one metric type's series start in a push
+type seriesGroup struct {
+	metric  string
+	start   int
+	reading string // series name of the metric's own readings, as opposed to derived series
+}
+
+// capGroups keeps at most limit readings per metric type, dropping the oldest
+// by timestamp. Derived series (gap, reading count, _smoothed) are always kept
+// and don't count towards the limit.
+func capGroups(timeseries []prompb.TimeSeries, groups []seriesGroup, limit int) ([]prompb.TimeSeries, []seriesGroup) {
+	capped := make([]prompb.TimeSeries, 0, len(timeseries))
+	cappedGroups := make([]seriesGroup, 0, len(groups))
+	for i, group := range groups {
+		end := len(timeseries)
+		if i+1 < len(groups) {
+			end = groups[i+1].start
+		}
+		var readings []int64
+		for _, ts := range timeseries[group.start:end] {
+			if seriesName(ts) == group.reading {
+				readings = append(readings, ts.Samples[0].Timestamp)
+			}
+		}
+		// Readings older than the limit-th newest are dropped
+		cutoff := int64(math.MinInt64)
+		if len(readings) > limit {
+			sort.Slice(readings, func(i, j int) bool { return readings[i] > readings[j] })
+			cutoff = readings[limit-1]
+			log.Printf("Capping %s at %d samples (dropping %d older)", group.metric, limit, len(readings)-limit)
+		}
+		cappedGroups = append(cappedGroups, seriesGroup{metric: group.metric, start: len(capped), reading: group.reading})
+		for _, ts := range timeseries[group.start:end] {
+			if seriesName(ts) == group.reading && ts.Samples[0].Timestamp < cutoff {
+				continue
+			}
+			capped = append(capped, ts)
+		}
+	}
+	return capped, cappedGroups
+}
+
+// dropOldGroups removes series whose sample is older than minMs
+// (--max-sample-age), so strict receivers don't reject the whole batch
+func dropOldGroups(timeseries []prompb.TimeSeries, groups []seriesGroup, minMs int64) ([]prompb.TimeSeries, []seriesGroup) {
+	kept := make([]prompb.TimeSeries, 0, len(timeseries))
+	keptGroups := make([]seriesGroup, 0, len(groups))
+	dropped := 0
+	for i, group := range groups {
+		end := len(timeseries)
+		if i+1 < len(groups) {
+			end = groups[i+1].start
+		}
+		keptGroups = append(keptGroups, seriesGroup{metric: group.metric, start: len(kept), reading: group.reading})
+		for _, ts := range timeseries[group.start:end] {
+			if ts.Samples[len(ts.Samples)-1].Timestamp < minMs {
+				dropped++
+				continue
+			}
+			kept = append(kept, ts)
+		}
+	}
+	if dropped > 0 {
+		log.Printf("Dropping %d samples older than --max-sample-age", dropped)
+	}
+	return kept, keptGroups
+}
+
+// ingestGroups restamps every sample with nowMs (--sample-timestamp-mode
+// ingest). Samples sharing a timestamp would conflict, so only the newest
+// sample of each series in a group is kept.
+func ingestGroups(timeseries []prompb.TimeSeries, groups []seriesGroup, nowMs int64) ([]prompb.TimeSeries, []seriesGroup) {
+	stamped := make([]prompb.TimeSeries, 0, len(timeseries))
+	stampedGroups := make([]seriesGroup, 0, len(groups))
+	for i, group := range groups {
+		end := len(timeseries)
+		if i+1 < len(groups) {
+			end = groups[i+1].start
+		}
+		stampedGroups = append(stampedGroups, seriesGroup{metric: group.metric, start: len(stamped), reading: group.reading})
+		index := make(map[string]int) // labels -> position in stamped
+		for _, ts := range timeseries[group.start:end] {
+			ts.Samples = []prompb.Sample{{Value: ts.Samples[len(ts.Samples)-1].Value, Timestamp: nowMs}}
+			key := labelsKey(ts.Labels)
+			if j, ok := index[key]; ok {
+				stamped[j] = ts
+				continue
+			}
+			index[key] = len(stamped)
+			stamped = append(stamped, ts)
+		}
+	}
+	return stamped, stampedGroups
+}
+
+// writeGroups writes each metric type's series separately (--keep-going), so
+// a receiver rejecting one metric doesn't block the others. Failures are
+// logged and returned together, along with the metric types that failed.
+func writeGroups(exporter Exporter, timeseries []prompb.TimeSeries, groups []seriesGroup) (map[string]bool, error) {
+	var errs []error
+	failed := make(map[string]bool)
+	for i, group := range groups {
+		end := len(timeseries)
+		if i+1 < len(groups) {
+			end = groups[i+1].start
+		}
+		if end == group.start {
+			continue
+		}
+		if err := exporter.Write(timeseries[group.start:end]); err != nil {
+			log.Printf("Push %s failed: %v", group.metric, err)
+			errs = append(errs, fmt.Errorf("%s: %w", group.metric, err))
+			failed[group.metric] = true
+		}
+	}
+	return failed, errors.Join(errs...)
+}
+
+// observeAlerts evaluates the alert rules against the readings the exporter
+// accepted. Derived series (gap, reading count, _smoothed) aren't readings.
+func observeAlerts(account string, timeseries []prompb.TimeSeries, groups []seriesGroup) {
+	for i, group := range groups {
+		end := len(timeseries)
+		if i+1 < len(groups) {
+			end = groups[i+1].start
+		}
+		for _, ts := range timeseries[group.start:end] {
+			if seriesName(ts) != group.reading {
+				continue
+			}
+			for _, sample := range ts.Samples {
+				alerts.observe(account, group.metric, sample.Value, sample.Timestamp/1000)
+			}
+		}
+	}
+}
+
+// dropFailedGroups removes the series of the metric types in failed
+func dropFailedGroups(timeseries []prompb.TimeSeries, groups []seriesGroup, failed map[string]bool) ([]prompb.TimeSeries, []seriesGroup) {
+	kept := make([]prompb.TimeSeries, 0, len(timeseries))
+	keptGroups := make([]seriesGroup, 0, len(groups))
+	for i, group := range groups {
+		if failed[group.metric] {
+			continue
+		}
+		end := len(timeseries)
+		if i+1 < len(groups) {
+			end = groups[i+1].start
+		}
+		keptGroups = append(keptGroups, seriesGroup{metric: group.metric, start: len(kept), reading: group.reading})
+		kept = append(kept, timeseries[group.start:end]...)
+	}
+	return kept, keptGroups
+}
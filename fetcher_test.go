@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// recordingExporter keeps every batch written and fails while err is set
+type recordingExporter struct {
+	err     error
+	batches [][]prompb.TimeSeries
+}
+
+func (e *recordingExporter) Write(timeseries []prompb.TimeSeries) error {
+	e.batches = append(e.batches, timeseries)
+	return e.err
+}
+
+// samples flattens the written batches into name -> timestamps (ms)
+func (e *recordingExporter) samples() map[string][]int64 {
+	out := make(map[string][]int64)
+	for _, batch := range e.batches {
+		for _, ts := range batch {
+			for _, sample := range ts.Samples {
+				out[seriesName(ts)] = append(out[seriesName(ts)], sample.Timestamp)
+			}
+		}
+	}
+	return out
+}
+
+func testFetcher(t *testing.T) *Fetcher {
+	t.Helper()
+	return newFetcher(defaultConfig(), nil, "", Account{})
+}
+
+func timeseriesMetric(t *testing.T, metricType string, object map[string]any) Metric {
+	t.Helper()
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Metric{Type: metricType, Object: data}
+}
+
+func readings(pairs ...int64) []map[string]any {
+	var values []map[string]any
+	for i := 0; i+1 < len(pairs); i += 2 {
+		values = append(values, map[string]any{"value": pairs[i], "timestamp": pairs[i+1]})
+	}
+	return values
+}
+
+func TestPushRetriesReadingsAfterFailedWrite(t *testing.T) {
+	f := testFetcher(t)
+	metrics := []Metric{
+		timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate", "values": readings(60, 100, 61, 200)}),
+		timeseriesMetric(t, "sleep_score", map[string]any{"value": 80, "day_start_timestamp": 50}),
+	}
+
+	exporter := &recordingExporter{err: errors.New("receiver down")}
+	if err := f.pushMetrics(metrics, exporter); err == nil {
+		t.Fatal("pushMetrics: want the write error")
+	}
+	if got := f.LatestTimestamp(); got != 0 {
+		t.Errorf("LatestTimestamp after failed write = %d, want 0", got)
+	}
+
+	exporter.err = nil
+	if err := f.pushMetrics(metrics, exporter); err != nil {
+		t.Fatalf("pushMetrics: %v", err)
+	}
+	if len(exporter.batches) != 2 || len(exporter.batches[0]) != len(exporter.batches[1]) {
+		t.Fatalf("retry wrote %d series, first attempt %d; want the same batch again", len(exporter.batches[1]), len(exporter.batches[0]))
+	}
+
+	// Once written, nothing is sent again
+	if err := f.pushMetrics(metrics, exporter); err != nil {
+		t.Fatalf("pushMetrics: %v", err)
+	}
+	if len(exporter.batches) != 2 {
+		t.Errorf("third push wrote again: %d batches", len(exporter.batches))
+	}
+}
+
+func TestPushKeepGoingRetriesOnlyFailedMetric(t *testing.T) {
+	f := testFetcher(t)
+	f.cfg.KeepGoing = true
+	metrics := []Metric{
+		timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate", "values": readings(60, 100)}),
+		timeseriesMetric(t, "hrv", map[string]any{"title": "HRV", "values": readings(40, 100)}),
+	}
+
+	exporter := &failingMetricExporter{fail: "ultrahuman_hrv_ms"}
+	f.pushMetrics(metrics, exporter)
+	exporter.fail = ""
+	exporter.written = nil
+	if err := f.pushMetrics(metrics, exporter); err != nil {
+		t.Fatalf("pushMetrics: %v", err)
+	}
+	if exporter.written["ultrahuman_heart_rate_bpm"] != 0 || exporter.written["ultrahuman_hrv_ms"] != 1 {
+		t.Errorf("second push wrote %v, want only the hrv reading", exporter.written)
+	}
+}
+
+// failingMetricExporter rejects batches containing the series named fail
+type failingMetricExporter struct {
+	fail    string
+	written map[string]int
+}
+
+func (e *failingMetricExporter) Write(timeseries []prompb.TimeSeries) error {
+	for _, ts := range timeseries {
+		if seriesName(ts) == e.fail {
+			return errors.New("rejected")
+		}
+	}
+	if e.written == nil {
+		e.written = make(map[string]int)
+	}
+	for _, ts := range timeseries {
+		e.written[seriesName(ts)]++
+	}
+	return nil
+}
+
+func TestSpoolDoesNotRespoolDuringOutage(t *testing.T) {
+	dir := t.TempDir()
+	backend := &recordingExporter{err: errors.New("connection refused")}
+	spool, err := newSpoolExporter(backend, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := testFetcher(t)
+	metrics := []Metric{timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate", "values": readings(60, 100, 61, 200)})}
+	for cycle := 0; cycle < 4; cycle++ {
+		if err := f.pushMetrics(metrics, spool); err != nil {
+			t.Fatalf("cycle %d: spooled write returned %v", cycle, err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolFileExt))
+	if len(files) != 1 {
+		t.Fatalf("got %d spool files after 4 failed cycles, want 1", len(files))
+	}
+
+	backend.err = nil
+	backend.batches = nil
+	if err := spool.Write(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := backend.samples()["ultrahuman_heart_rate_bpm"]; len(got) != 2 {
+		t.Errorf("replayed hr samples %v, want the 2 readings once", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spool not emptied after replay: %d files", len(entries))
+	}
+}
+
+func TestSpoolKeepsOnlyFailedBatches(t *testing.T) {
+	var received []string
+	reject := map[string]int{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		var req prompb.WriteRequest
+		if err == nil {
+			err = req.Unmarshal(data)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, ts := range req.Timeseries {
+			if status := reject[seriesName(ts)]; status != 0 {
+				http.Error(w, "unavailable", status)
+				return
+			}
+		}
+		for _, ts := range req.Timeseries {
+			received = append(received, seriesName(ts))
+		}
+	}))
+	defer receiver.Close()
+
+	client := NewRemoteWriteClient(receiver.URL, nil)
+	client.batchSize = 1
+	dir := t.TempDir()
+	spool, err := newSpoolExporter(client, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The middle batch fails; only it is spooled
+	reject["b"] = http.StatusServiceUnavailable
+	if err := spool.Write([]prompb.TimeSeries{
+		buildTimeSeries("a", 1, 1000, nil),
+		buildTimeSeries("b", 2, 1000, nil),
+		buildTimeSeries("c", 3, 1000, nil),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(received) != "[a c]" {
+		t.Fatalf("receiver got %v, want [a c]", received)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+spoolFileExt))
+	if len(files) != 1 {
+		t.Fatalf("got %d spool files, want 1", len(files))
+	}
+	spooled, err := readSpoolFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spooled) != 1 || seriesName(spooled[0]) != "b" {
+		t.Fatalf("spooled %d series, want only b", len(spooled))
+	}
+
+	// While b is still spooled, d and e are spooled behind it whole. Replay
+	// then sends b and d, fails on e and rewrites that file with only e.
+	reject["e"] = http.StatusServiceUnavailable
+	if err := spool.Write([]prompb.TimeSeries{
+		buildTimeSeries("d", 4, 1000, nil),
+		buildTimeSeries("e", 5, 1000, nil),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	delete(reject, "b")
+	received = nil
+	if err := spool.replay(); err == nil {
+		t.Fatal("replay succeeded while e is still rejected")
+	}
+	if fmt.Sprint(received) != "[b d]" {
+		t.Fatalf("replay sent %v, want [b d]", received)
+	}
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+spoolFileExt))
+	if len(files) != 1 {
+		t.Fatalf("got %d spool files after partial replay, want 1", len(files))
+	}
+	if spooled, _ = readSpoolFile(files[0]); len(spooled) != 1 || seriesName(spooled[0]) != "e" {
+		t.Fatalf("spool file holds %d series after partial replay, want only e", len(spooled))
+	}
+
+	delete(reject, "e")
+	received = nil
+	if err := spool.replay(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(received) != "[e]" {
+		t.Errorf("replay sent %v, want [e]", received)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spool not emptied after replay: %d files", len(entries))
+	}
+}
+
+func TestPushMultiDaySimpleMetricsSettle(t *testing.T) {
+	f := testFetcher(t)
+	resp := &APIResponse{Data: Data{Metrics: map[string][]Metric{
+		"2024-01-01": {timeseriesMetric(t, "sleep_score", map[string]any{"value": 80, "day_start_timestamp": 1704067200})},
+		"2024-01-02": {timeseriesMetric(t, "sleep_score", map[string]any{"value": 72, "day_start_timestamp": 1704153600})},
+	}}}
+
+	exporter := &recordingExporter{}
+	if err := f.Push(resp, exporter); err != nil {
+		t.Fatal(err)
+	}
+	got := exporter.samples()["ultrahuman_sleep_score"]
+	if len(got) != 2 || got[0] != 1704067200000 || got[1] != 1704153600000 {
+		t.Fatalf("first push sleep_score timestamps %v, want both day starts", got)
+	}
+
+	for cycle := 0; cycle < 3; cycle++ {
+		exporter.batches = nil
+		if err := f.Push(resp, exporter); err != nil {
+			t.Fatal(err)
+		}
+		if got := exporter.samples(); len(got) != 0 {
+			t.Fatalf("cycle %d re-pushed unchanged daily values: %v", cycle, got)
+		}
+	}
+}
+
+func TestCapGroupsKeepsNewestReadings(t *testing.T) {
+	hr := exportedName("ultrahuman_heart_rate_bpm")
+	var timeseries []prompb.TimeSeries
+	for _, ts := range []int64{300, 100, 400, 200} {
+		timeseries = append(timeseries,
+			buildTimeSeries("ultrahuman_heart_rate_bpm", 60, ts, nil),
+			buildTimeSeries("ultrahuman_heart_rate_bpm_smoothed", 60, ts, nil))
+	}
+	timeseries = append(timeseries,
+		buildTimeSeries("ultrahuman_reading_gap_seconds", 100, 400, nil),
+		buildTimeSeries("ultrahuman_reading_count", 4, 400, nil))
+	groups := []seriesGroup{{metric: "hr", start: 0, reading: hr}}
+
+	capped, cappedGroups := capGroups(timeseries, groups, 2)
+	var kept []int64
+	others := 0
+	for _, ts := range capped {
+		if seriesName(ts) == hr {
+			kept = append(kept, ts.Samples[0].Timestamp)
+		} else {
+			others++
+		}
+	}
+	if len(kept) != 2 || kept[0] != 300 || kept[1] != 400 {
+		t.Errorf("kept readings %v, want the newest two [300 400]", kept)
+	}
+	if others != 6 {
+		t.Errorf("kept %d derived series, want all 6", others)
+	}
+	if len(cappedGroups) != 1 || cappedGroups[0].reading != hr {
+		t.Errorf("groups %+v lost the reading name", cappedGroups)
+	}
+}
+
+func TestFetchSinceMergesPartialDay(t *testing.T) {
+	responses := []string{
+		`{"status":200,"data":{"metrics":{"2024-01-01":[
+			{"type":"hr","object":{"title":"Heart Rate","day_start_timestamp":1704067200,"values":[{"value":60,"timestamp":1704067300},{"value":61,"timestamp":1704067600}]}},
+			{"type":"steps","object":{"title":"Steps","day_start_timestamp":1704067200,"values":[{"value":100,"timestamp":1704067300}]}}]}}}`,
+		`{"status":200,"data":{"metrics":{"2024-01-01":[
+			{"type":"hr","object":{"title":"Heart Rate","day_start_timestamp":1704067200,"values":[{"value":61,"timestamp":1704067600},{"value":62,"timestamp":1704067900}]}},
+			{"type":"steps","object":{"title":"Steps","day_start_timestamp":1704067200,"values":[{"value":50,"timestamp":1704067900}]}}]}}}`,
+	}
+	var sinces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sinces = append(sinces, r.URL.Query().Get("since"))
+		w.Write([]byte(responses[len(sinces)-1]))
+	}))
+	defer server.Close()
+
+	cfg := defaultConfig()
+	cfg.APISinceParam = "since"
+	f := newFetcher(cfg, server.Client(), server.URL, Account{})
+	exporter := &recordingExporter{}
+	for range responses {
+		resp, err := f.Fetch(context.Background(), "2024-01-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		exporter.batches = nil
+		if err := f.Push(resp, exporter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// steps has the older newest reading, so the bound comes from it
+	if sinces[0] != "" || sinces[1] != "1704067300" {
+		t.Errorf("since params %q, want none then the oldest newest reading", sinces)
+	}
+	values := func(name string) []float64 {
+		var out []float64
+		for _, batch := range exporter.batches {
+			for _, ts := range batch {
+				if seriesName(ts) == name {
+					out = append(out, ts.Samples[0].Value)
+				}
+			}
+		}
+		return out
+	}
+	if got := values("ultrahuman_steps_total"); len(got) != 1 || got[0] != 150 {
+		t.Errorf("steps after partial fetch %v, want the running total [150]", got)
+	}
+	if got := values("ultrahuman_reading_count"); len(got) != 2 || got[0] != 3 {
+		t.Errorf("reading counts after partial fetch %v, want 3 hr readings (and 2 steps)", got)
+	}
+	if got := values("ultrahuman_heart_rate_bpm"); len(got) != 1 || got[0] != 62 {
+		t.Errorf("hr after partial fetch %v, want only the new reading", got)
+	}
+}
+
+func TestPushTwoDayResponse(t *testing.T) {
+	f := testFetcher(t)
+	resp := &APIResponse{Data: Data{Metrics: map[string][]Metric{
+		"2024-01-02": {timeseriesMetric(t, "steps", map[string]any{"title": "Steps", "day_start_timestamp": 1704153600, "values": readings(30, 1704153700, 20, 1704154000)})},
+		"2024-01-01": {timeseriesMetric(t, "steps", map[string]any{"title": "Steps", "day_start_timestamp": 1704067200, "values": readings(100, 1704067300, 250, 1704150000)})},
+	}}}
+
+	exporter := &recordingExporter{}
+	if err := f.Push(resp, exporter); err != nil {
+		t.Fatal(err)
+	}
+	var totals []float64
+	for _, batch := range exporter.batches {
+		for _, ts := range batch {
+			if seriesName(ts) == "ultrahuman_steps_total" {
+				totals = append(totals, ts.Samples[0].Value)
+			}
+		}
+	}
+	// Each day's running total, the older day first
+	want := []float64{100, 350, 30, 50}
+	if len(totals) != len(want) {
+		t.Fatalf("steps totals %v, want %v", totals, want)
+	}
+	for i := range want {
+		if totals[i] != want[i] {
+			t.Fatalf("steps totals %v, want %v", totals, want)
+		}
+	}
+}
+
+func TestPushShuffledReadingsOnce(t *testing.T) {
+	f := testFetcher(t)
+	first := []Metric{timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate",
+		"values": readings(62, 1704067900, 60, 1704067300, 63, 1704068200, 61, 1704067600)})}
+	// The next cycle returns the same readings plus newer ones, shuffled again
+	second := []Metric{timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate",
+		"values": readings(65, 1704068800, 61, 1704067600, 63, 1704068200, 64, 1704068500, 60, 1704067300, 62, 1704067900)})}
+
+	exporter := &recordingExporter{}
+	for _, metrics := range [][]Metric{first, second} {
+		if err := f.pushMetrics(metrics, exporter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := exporter.samples()["ultrahuman_heart_rate_bpm"]
+	want := []int64{1704067300000, 1704067600000, 1704067900000, 1704068200000, 1704068500000, 1704068800000}
+	if len(got) != len(want) {
+		t.Fatalf("pushed timestamps %v, want each of %v once", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pushed timestamps %v, want %v in order", got, want)
+		}
+	}
+}
+
+func TestFetcherFetchAndPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+		want    map[string]int // series name -> samples pushed
+	}{
+		{
+			name: "readings and daily value",
+			body: `{"status":200,"data":{"metrics":{"2024-01-01":[
+				{"type":"hr","object":{"title":"Heart Rate","values":[{"value":60,"timestamp":1704067300},{"value":61,"timestamp":1704067600}]}},
+				{"type":"sleep_score","object":{"value":80,"day_start_timestamp":1704067200}}]}}}`,
+			want: map[string]int{"ultrahuman_heart_rate_bpm": 2, "ultrahuman_sleep_score": 1, "ultrahuman_reading_count": 1, "ultrahuman_reading_gap_seconds": 1},
+		},
+		{
+			name: "unknown types are skipped",
+			body: `{"status":200,"data":{"metrics":{"2024-01-01":[{"type":"new_score","object":{"value":7}}]}}}`,
+			want: map[string]int{},
+		},
+		{
+			name:    "API error",
+			body:    `{"status":200,"error":"invalid date","data":{"metrics":{}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			f := newFetcher(defaultConfig(), server.Client(), server.URL, Account{})
+			resp, err := f.Fetch(context.Background(), "2024-01-01")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			exporter := &recordingExporter{}
+			if err := f.Push(resp, exporter); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]int)
+			for name, samples := range exporter.samples() {
+				got[name] = len(samples)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("pushed %v, want %v", got, tt.want)
+			}
+			for name, n := range tt.want {
+				if got[name] != n {
+					t.Errorf("pushed %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchersKeepSeparateState(t *testing.T) {
+	metrics := []Metric{timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate", "values": readings(60, 1704067300)})}
+	a, b := testFetcher(t), testFetcher(t)
+	exporter := &recordingExporter{}
+	if err := a.pushMetrics(metrics, exporter); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.pushMetrics(metrics, exporter); err != nil {
+		t.Fatal(err)
+	}
+	if got := exporter.samples()["ultrahuman_heart_rate_bpm"]; len(got) != 2 {
+		t.Errorf("two fetchers pushed the reading %d times, want once each", len(got))
+	}
+	if a.LatestTimestamp() != 1704067300 || b.LatestTimestamp() != 1704067300 {
+		t.Errorf("LatestTimestamp = %d, %d", a.LatestTimestamp(), b.LatestTimestamp())
+	}
+}
+
+func TestPushZeroValues(t *testing.T) {
+	f := testFetcher(t)
+	metrics := []Metric{
+		timeseriesMetric(t, "steps", map[string]any{"title": "Steps", "day_start_timestamp": 1704067200, "total": 0, "values": []any{}}),
+		timeseriesMetric(t, "active_minutes", map[string]any{"value": 0, "day_start_timestamp": 1704067200}),
+		timeseriesMetric(t, "recovery", map[string]any{"value": nil, "day_start_timestamp": 1704067200}),
+		timeseriesMetric(t, "hrv", map[string]any{}), // absent, not zero
+	}
+	exporter := &recordingExporter{}
+	if err := f.pushMetrics(metrics, exporter); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]float64)
+	for _, batch := range exporter.batches {
+		for _, ts := range batch {
+			got[seriesName(ts)] = ts.Samples[0].Value
+			if ts.Samples[0].Timestamp != 1704067200000 {
+				t.Errorf("%s stamped at %d, want the day start", seriesName(ts), ts.Samples[0].Timestamp)
+			}
+		}
+	}
+	for _, name := range []string{"ultrahuman_steps_total", "ultrahuman_active_minutes", "ultrahuman_reading_count"} {
+		if value, ok := got[name]; !ok || value != 0 {
+			t.Errorf("%s = %v (pushed %v), want a 0 sample", name, value, ok)
+		}
+	}
+	if _, ok := got["ultrahuman_recovery"]; ok {
+		t.Error("null recovery was pushed")
+	}
+	if len(got) != 3 {
+		t.Errorf("pushed %v, want only the zero values", got)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// FileExporter writes the ring data of the pull /metrics endpoint to a file
+// in the Prometheus text format, for shipping by another process. The file is
+// rendered from the cached responses, so the series passed to Write only
+// signal that there is something new.
+type FileExporter struct {
+	cfg  *Config
+	path string
+}
+
+func NewFileExporter(cfg *Config, path string) *FileExporter {
+	return &FileExporter{cfg: cfg, path: path}
+}
+
+func (e *FileExporter) Write([]prompb.TimeSeries) error {
+	var buf bytes.Buffer
+	writePrometheusText(&buf, collectPullFamilies(e.cfg))
+	return writeFileAtomic(e.path, buf.Bytes())
+}
+
+// textfileName is the file written into --textfile-dir. node_exporter's
+// textfile collector reads only *.prom files, so the temp file it is renamed
+// from is never picked up half written.
+const textfileName = "ultrahuman.prom"
+
+// checkTextfileDir verifies --textfile-dir is an existing directory
+func checkTextfileDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("--textfile-dir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("--textfile-dir: %s is not a directory", dir)
+	}
+	return nil
+}
+
+// writeTextfile renders the ring data and the exporter's telemetry, with
+// HELP and TYPE headers, into --textfile-dir for node_exporter. It runs every
+// cycle, so a failed fetch still refreshes the up and staleness gauges.
+func writeTextfile(cfg *Config) error {
+	var buf bytes.Buffer
+	writePrometheusText(&buf, pullMetricFamilies(cfg, true))
+	return writeFileAtomic(filepath.Join(cfg.TextfileDir, textfileName), buf.Bytes())
+}
+
+// writeFileAtomic replaces path with data through a temp file in the same
+// directory, so readers see either the old or the new file, never a partial one
+func writeFileAtomic(path string, data []byte) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// CreateTemp makes the file private; the shipping process may run as another user
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
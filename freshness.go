@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// freshnessResult is the --output json form of the freshness subcommand
+type freshnessResult struct {
+	Metric          string `json:"metric"`
+	LatestTimestamp int64  `json:"latest_timestamp"` // 0 when there are no readings
+	AgeSeconds      int64  `json:"age_seconds"`
+	MaxAgeSeconds   int64  `json:"max_age_seconds"`
+	Fresh           bool   `json:"fresh"`
+}
+
+// runFreshness implements "freshness --metric M --max-age D" for cron-driven
+// alerting: it exits 0 if the newest reading of a time series metric is at
+// most D old, 1 if it is older or there is none, 2 on bad usage and 3 if the
+// API can't be reached.
+func runFreshness(cfg *Config, client *http.Client, token string, args []string) int {
+	fs := flag.NewFlagSet("freshness", flag.ContinueOnError)
+	metric := fs.String("metric", "", "Time series metric to check, e.g. hr")
+	maxAge := fs.Duration("max-age", 15*time.Minute, "Newest reading may be at most this old")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if config, ok := metricRegistry[*metric]; !ok || config.MetricType != "timeseries" {
+		fmt.Fprintln(os.Stderr, "Error: freshness needs --metric with a time series metric (hr, hrv, temp, spo2, steps, glucose, motion)")
+		return 2
+	}
+	if *maxAge <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --max-age must be positive")
+		return 2
+	}
+
+	now := time.Now().In(cfg.location())
+	latest, err := latestReading(client, token, *metric, now.Format("2006-01-02"))
+	// Shortly after midnight today may have no readings yet while
+	// yesterday's last ones are still recent enough
+	if err == nil && now.Sub(time.Unix(latest, 0)) > *maxAge {
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		if now.Sub(dayStart) < *maxAge {
+			var previous int64
+			previous, err = latestReading(client, token, *metric, dayStart.AddDate(0, 0, -1).Format("2006-01-02"))
+			latest = max(latest, previous)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 3
+	}
+
+	result := freshnessResult{Metric: *metric, LatestTimestamp: latest, MaxAgeSeconds: int64(*maxAge / time.Second)}
+	age := now.Sub(time.Unix(latest, 0)).Truncate(time.Second)
+	if latest > 0 {
+		result.AgeSeconds = int64(age / time.Second)
+		result.Fresh = age <= *maxAge
+	}
+
+	if cfg.Output == "json" {
+		newJSONEncoder(os.Stdout).Encode(result)
+	} else {
+		status := "STALE"
+		if result.Fresh {
+			status = "OK"
+		}
+		if latest == 0 {
+			fmt.Printf("%s: %s, no readings today\n", *metric, status)
+		} else {
+			fmt.Printf("%s: %s, newest reading %s (%s ago, max %s)\n", *metric, status,
+				time.Unix(latest, 0).In(cfg.location()).Format("2006-01-02 15:04"), age, *maxAge)
+		}
+	}
+	if !result.Fresh {
+		return 1
+	}
+	return 0
+}
+
+// latestReading returns the newest reading timestamp of a time series metric
+// on date, or 0 if it has none
+func latestReading(client *http.Client, token, metric, date string) (int64, error) {
+	metrics, err := fetchDay(client, token, date)
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, m := range metrics {
+		if m.Type != metric {
+			continue
+		}
+		var v TimeSeriesMetric
+		if err := json.Unmarshal(m.Object, &v); err != nil {
+			continue
+		}
+		latest = max(latest, getLatestTimestamp(v.Values))
+	}
+	return latest, nil
+}
@@ -4,7 +4,10 @@ go 1.25.5
 
 require (
 	github.com/golang/snappy v1.0.0
+	github.com/klauspost/compress v1.18.2
 	github.com/prometheus/prometheus v0.309.0
+	go.yaml.in/yaml/v2 v2.4.3
+	golang.org/x/term v0.38.0
 )
 
 require (
@@ -14,7 +17,7 @@ require (
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/prometheus/client_model v0.6.2 // indirect
 	github.com/prometheus/common v0.67.4 // indirect
-	go.yaml.in/yaml/v2 v2.4.3 // indirect
+	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/text v0.32.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 )
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// GraphiteClient writes samples to a Carbon endpoint using the plaintext
+// protocol: "<path>[;tag=value...] <value> <unix seconds>\n"
+type GraphiteClient struct {
+	address string
+	prefix  string
+	timeout time.Duration
+}
+
+func NewGraphiteClient(address, prefix string) *GraphiteClient {
+	return &GraphiteClient{
+		address: address,
+		prefix:  strings.Trim(prefix, "."),
+		timeout: 30 * time.Second,
+	}
+}
+
+func (c *GraphiteClient) Write(timeseries []prompb.TimeSeries) error {
+	var buf bytes.Buffer
+	for _, ts := range timeseries {
+		path := c.metricPath(ts)
+		for _, sample := range ts.Samples {
+			fmt.Fprintf(&buf, "%s %g %d\n", path, sample.Value, sample.Timestamp/1000)
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		return fmt.Errorf("connecting to graphite: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing to graphite: %w", err)
+	}
+	return nil
+}
+
+// metricPath converts ultrahuman_heart_rate_bpm{account="me"} into
+// <prefix>.heart_rate_bpm;account=me
+func (c *GraphiteClient) metricPath(ts prompb.TimeSeries) string {
+	name := strings.TrimPrefix(seriesName(ts), metricPrefix)
+	path := name
+	if c.prefix != "" {
+		path = c.prefix + "." + name
+	}
+
+	var b strings.Builder
+	b.WriteString(path)
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			continue
+		}
+		fmt.Fprintf(&b, ";%s=%s", l.Name, graphiteTagValue(l.Value))
+	}
+	return b.String()
+}
+
+// graphiteTagValue strips characters the Graphite tag syntax reserves
+func graphiteTagValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ';', '~', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
@@ -2,18 +2,27 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/prometheus/prometheus/prompb"
 )
 
@@ -21,6 +30,8 @@ type APIResponse struct {
 	Data   Data    `json:"data"`
 	Error  *string `json:"error"`
 	Status int     `json:"status"`
+
+	raw []byte // undecoded body, kept for --record-dir
 }
 
 type Data struct {
@@ -28,6 +39,16 @@ type Data struct {
 	LatestTimeZone string              `json:"latest_time_zone"`
 }
 
+// sortedDates returns the dates in Metrics, oldest first
+func (d Data) sortedDates() []string {
+	dates := make([]string, 0, len(d.Metrics))
+	for date := range d.Metrics {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates
+}
+
 type Metric struct {
 	Type   string          `json:"type"`
 	Object json.RawMessage `json:"object"`
@@ -49,12 +70,72 @@ type TimeSeriesMetric struct {
 	Total             float64     `json:"total"`
 }
 
+// summary returns the API's day summary selected by a MetricConfig.Field
+func (v TimeSeriesMetric) summary(field string) float64 {
+	switch field {
+	case "avg":
+		return v.Avg
+	case "total":
+		return v.Total
+	}
+	return v.LastReading
+}
+
+// present reports whether the API returned the metric at all, as opposed to
+// an empty object. A present metric may legitimately be all zeros.
+func (v TimeSeriesMetric) present() bool {
+	return v.Title != "" || len(v.Values) > 0
+}
+
 type SimpleMetric struct {
 	Value             *float64 `json:"value"`
 	Title             string   `json:"title"`
 	DayStartTimestamp int64    `json:"day_start_timestamp"`
 }
 
+// UnmarshalJSON accepts the value as a JSON number or a quoted number.
+// null, a missing value and an empty string all leave Value nil.
+func (m *SimpleMetric) UnmarshalJSON(data []byte) error {
+	type alias SimpleMetric
+	aux := struct {
+		*alias
+		Value json.RawMessage `json:"value"`
+	}{alias: (*alias)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m.Value = nil
+	raw := bytes.TrimSpace(aux.Value)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("parsing value %q: %w", s, err)
+		}
+		m.Value = &f
+		return nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return err
+	}
+	m.Value = &f
+	return nil
+}
+
 type SleepMetric struct {
 	DayStartTimestamp int64    `json:"day_start_timestamp"`
 	Score             *float64 `json:"score"`
@@ -66,44 +147,244 @@ type SleepMetric struct {
 	RemSleep          *float64 `json:"rem_sleep"`
 }
 
-// Track the latest timestamp seen across all metrics
-var globalLatestTimestamp int64
+const dailyMetricsURL = "https://partner.ultrahuman.com/api/v1/partner/daily_metrics"
 
-// Track last pushed timestamp per metric to avoid duplicates
+// apiURL is the endpoint queried: the --endpoint's URL unless --api-url is set
+var apiURL = dailyMetricsURL
+
+// errUnauthorized is returned when the API rejects the token
+var errUnauthorized = errors.New("unauthorized: API token was rejected")
+
+// Track fetch cycle outcomes for the /ready and /status endpoints
 var (
-	lastPushedTimestamp = make(map[string]int64)
-	lastPushedMu        sync.Mutex
+	lastSuccessfulFetch time.Time
+	lastFetchError      string
+	fetchCount          int
+	consecutiveFailures int
+	initialFetchDone    bool // the first serve-mode cycle finished, successfully or not
+	fetchStatusMu       sync.Mutex
 )
 
+// statusResponse is the /status JSON payload
+type statusResponse struct {
+	Status               string           `json:"status"`
+	Version              string           `json:"version"`
+	LastDataTimestamp    int64            `json:"last_data_timestamp"`
+	IntervalSeconds      int              `json:"interval_seconds"`
+	Accounts             map[string]int64 `json:"accounts,omitempty"`
+	InitialFetchComplete bool             `json:"initial_fetch_complete"`
+	LastSuccessTimestamp int64            `json:"last_success_timestamp"` // unix seconds, 0 before the first success
+	LastError            string           `json:"last_error"`
+	FetchCount           int              `json:"fetch_count"`
+	ConsecutiveFailures  int              `json:"consecutive_failures"`
+}
+
 // RemoteWriteClient sends metrics to a Prometheus remote write endpoint
 type RemoteWriteClient struct {
 	url    string
 	client *http.Client
+
+	fallbackURL  string    // secondary receiver, tried when the primary fails
+	failedOverAt time.Time // when writes moved to the fallback; zero while the primary is healthy
+
+	batchSize        int // maximum series per write request
+	lastMetadataSent time.Time
+
+	username string // basic auth, when set
+	password string
+
+	encoding string        // Content-Encoding of write requests: "snappy" or "zstd"
+	zstd     *zstd.Encoder // set when encoding is "zstd"
 }
 
-func NewRemoteWriteClient(url string) *RemoteWriteClient {
+// metadataInterval controls how often metric metadata is attached to a write request
+const metadataInterval = 10 * time.Minute
+
+const defaultBatchSize = 500
+
+// primaryRetryInterval is how long writes stay on the fallback endpoint
+// before the primary is tried again
+const primaryRetryInterval = 5 * time.Minute
+
+// NewRemoteWriteClient builds a client for url. A nil transport uses
+// http.DefaultTransport.
+func NewRemoteWriteClient(url string, transport http.RoundTripper) *RemoteWriteClient {
 	return &RemoteWriteClient{
-		url:    url,
-		client: &http.Client{Timeout: 30 * time.Second},
+		url:       url,
+		client:    &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		batchSize: defaultBatchSize,
+		encoding:  "snappy",
+	}
+}
+
+// setEncoding switches the compression used for write requests
+func (c *RemoteWriteClient) setEncoding(encoding string) error {
+	switch encoding {
+	case "", "snappy":
+		c.encoding, c.zstd = "snappy", nil
+	case "zstd":
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return err
+		}
+		c.encoding, c.zstd = "zstd", encoder
+	default:
+		return fmt.Errorf("invalid --remote-write-encoding %q (want snappy or zstd)", encoding)
+	}
+	return nil
+}
+
+// compress encodes a marshaled write request with the configured encoding
+func (c *RemoteWriteClient) compress(data []byte) []byte {
+	if c.zstd != nil {
+		return c.zstd.EncodeAll(data, nil)
 	}
+	return snappy.Encode(nil, data)
 }
 
+// remoteWriteError is a non-2xx response from the remote write endpoint
+type remoteWriteError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *remoteWriteError) Error() string {
+	return fmt.Sprintf("remote write failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// partialWriteError is returned when some batches of a write were accepted.
+// unsent holds the series of the batches that weren't, so a caller retrying
+// the write sends only those.
+type partialWriteError struct {
+	err    error
+	unsent []prompb.TimeSeries
+}
+
+func (e *partialWriteError) Error() string { return e.err.Error() }
+
+func (e *partialWriteError) Unwrap() error { return e.err }
+
+// isRetriable reports whether a write error may succeed if tried again.
+// 5xx, 429 and transport errors are retriable; other 4xx responses mean the
+// receiver rejected the data itself.
+func isRetriable(err error) bool {
+	var rwErr *remoteWriteError
+	if errors.As(err, &rwErr) {
+		return rwErr.StatusCode/100 == 5 || rwErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// Write sends timeseries in chunks of at most batchSize series. It stops on
+// the first non-retriable error and otherwise returns all retriable errors.
+// Once a batch has been accepted, failures come as a *partialWriteError
+// listing the series not sent; the rejected batch itself is not among them.
 func (c *RemoteWriteClient) Write(timeseries []prompb.TimeSeries) error {
+	batchSize := c.batchSize
+	if batchSize <= 0 {
+		batchSize = len(timeseries)
+	}
+
+	var errs []error
+	var unsent []prompb.TimeSeries
+	accepted := false
+	for start := 0; start < len(timeseries); start += batchSize {
+		end := min(start+batchSize, len(timeseries))
+		if err := c.writeBatch(timeseries[start:end]); err != nil {
+			if !isRetriable(err) {
+				if accepted || len(unsent) > 0 {
+					return &partialWriteError{err: err, unsent: append(unsent, timeseries[end:]...)}
+				}
+				return err
+			}
+			log.Printf("Remote write batch %d-%d failed: %v", start, end, err)
+			errs = append(errs, err)
+			unsent = append(unsent, timeseries[start:end]...)
+			continue
+		}
+		accepted = true
+	}
+	if len(errs) > 0 && accepted {
+		return &partialWriteError{err: errors.Join(errs...), unsent: unsent}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *RemoteWriteClient) writeBatch(timeseries []prompb.TimeSeries) error {
 	req := &prompb.WriteRequest{Timeseries: timeseries}
+	sendMetadata := time.Since(c.lastMetadataSent) >= metadataInterval
+	if sendMetadata {
+		req.Metadata = buildMetadata()
+	}
 	data, err := req.Marshal()
 	if err != nil {
 		return fmt.Errorf("marshaling write request: %w", err)
 	}
 
-	compressed := snappy.Encode(nil, data)
-	httpReq, err := http.NewRequest("POST", c.url, bytes.NewReader(compressed))
+	compressed := c.compress(data)
+	endpoints := c.endpoints()
+	for i, url := range endpoints {
+		err = c.post(url, compressed)
+		if err == nil {
+			c.markHealthy(url)
+			break
+		}
+		if !isRetriable(err) || i == len(endpoints)-1 {
+			break
+		}
+		log.Printf("Remote write to %s failed: %v; trying %s", url, err, endpoints[i+1])
+	}
+	if err != nil {
+		return err
+	}
+
+	if sendMetadata {
+		c.lastMetadataSent = time.Now()
+	}
+
+	return nil
+}
+
+// endpoints lists the URLs to try in order: the primary first, unless writes
+// failed over to the fallback less than primaryRetryInterval ago
+func (c *RemoteWriteClient) endpoints() []string {
+	if c.fallbackURL == "" {
+		return []string{c.url}
+	}
+	if !c.failedOverAt.IsZero() && time.Since(c.failedOverAt) < primaryRetryInterval {
+		return []string{c.fallbackURL, c.url}
+	}
+	return []string{c.url, c.fallbackURL}
+}
+
+// markHealthy records which endpoint accepted a write, logging failovers
+func (c *RemoteWriteClient) markHealthy(url string) {
+	switch {
+	case url == c.url && !c.failedOverAt.IsZero():
+		log.Printf("Remote write primary %s recovered", c.url)
+		c.failedOverAt = time.Time{}
+	case url == c.fallbackURL && c.failedOverAt.IsZero():
+		log.Printf("Remote write failing over to %s", c.fallbackURL)
+		c.failedOverAt = time.Now()
+	case url == c.fallbackURL && time.Since(c.failedOverAt) >= primaryRetryInterval:
+		// the primary was retried and is still down
+		c.failedOverAt = time.Now()
+	}
+}
+
+// post sends one compressed write request to url
+func (c *RemoteWriteClient) post(url string, compressed []byte) error {
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(compressed))
 	if err != nil {
 		return fmt.Errorf("creating request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/x-protobuf")
-	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Encoding", c.encoding)
 	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if c.username != "" || c.password != "" {
+		httpReq.SetBasicAuth(c.username, c.password)
+	}
 
 	resp, err := c.client.Do(httpReq)
 	if err != nil {
@@ -113,17 +394,69 @@ func (c *RemoteWriteClient) Write(timeseries []prompb.TimeSeries) error {
 
 	if resp.StatusCode/100 != 2 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("remote write failed with status %d: %s", resp.StatusCode, string(body))
+		return &remoteWriteError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
-
 	return nil
 }
 
-func buildTimeSeries(metricName string, value float64, timestampMs int64) prompb.TimeSeries {
+// buildMetadata derives type, help and unit metadata for every registered metric
+func buildMetadata() []prompb.MetricMetadata {
+	seen := make(map[string]bool)
+	var metadata []prompb.MetricMetadata
+	for _, config := range metricRegistry {
+		if config.PrometheusName == "" || seen[config.PrometheusName] {
+			continue
+		}
+		seen[config.PrometheusName] = true
+
+		metricType := prompb.MetricMetadata_GAUGE
+		if config.IsCounter {
+			metricType = prompb.MetricMetadata_COUNTER
+		}
+		metadata = append(metadata, prompb.MetricMetadata{
+			Type:             metricType,
+			MetricFamilyName: exportedName(config.PrometheusName),
+			Help:             config.DisplayName,
+			Unit:             config.Unit,
+		})
+	}
+	return metadata
+}
+
+// seriesRenames maps built-in series names to the names users want to see,
+// loaded from the rename section of the config file
+var seriesRenames map[string]string
+
+// builtinPrefix starts every series name in the registry
+const builtinPrefix = "ultrahuman_"
+
+// metricPrefix replaces builtinPrefix in exported names (--metric-prefix)
+var metricPrefix = builtinPrefix
+
+// exportedName maps a built-in series name to the name sent to receivers: an
+// explicit rename wins, otherwise builtinPrefix is swapped for metricPrefix
+func exportedName(name string) string {
+	if renamed, ok := seriesRenames[name]; ok {
+		return renamed
+	}
+	if rest, ok := strings.CutPrefix(name, builtinPrefix); ok {
+		return metricPrefix + rest
+	}
+	return name
+}
+
+func buildTimeSeries(metricName string, value float64, timestampMs int64, extraLabels map[string]string) prompb.TimeSeries {
+	labels := []prompb.Label{
+		{Name: "__name__", Value: exportedName(metricName)},
+	}
+	for name, v := range extraLabels {
+		labels = append(labels, prompb.Label{Name: name, Value: v})
+	}
+	// Remote write requires labels sorted by name
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
 	return prompb.TimeSeries{
-		Labels: []prompb.Label{
-			{Name: "__name__", Value: metricName},
-		},
+		Labels: labels,
 		Samples: []prompb.Sample{
 			{Value: value, Timestamp: timestampMs},
 		},
@@ -137,28 +470,69 @@ type MetricConfig struct {
 	DisplayName    string
 	Unit           string
 	IsDuration     bool
-	PrometheusName string // metric name for remote write
+	PrometheusName string      // metric name for remote write
+	IsCounter      bool        // sent as COUNTER in remote write metadata instead of GAUGE
+	Range          *ValueRange // readings outside this range are dropped before pushing
+	Decimals       int         // digits after the decimal point when displaying values
+	SmoothWindow   int         // readings averaged into a pushed <name>_smoothed series; 0 disables
+	SummaryOnly    bool        // push only the Field summary at the newest reading instead of every reading
+}
+
+// formatValue renders a value with the metric's display precision
+func (c MetricConfig) formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', c.Decimals, 64)
+}
+
+// formatWithUnit renders a value followed by its unit
+func (c MetricConfig) formatWithUnit(v float64, unit string) string {
+	return c.formatValue(v) + unitSuffix(unit)
+}
+
+// unitSuffix is the text appended after a value for unit; °C and % attach directly
+func unitSuffix(unit string) string {
+	switch unit {
+	case "":
+		return ""
+	case "°C", "%":
+		return unit
+	default:
+		return " " + unit
+	}
+}
+
+// ValueRange bounds plausible readings for a metric (inclusive)
+type ValueRange struct {
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+}
+
+// inRange reports whether value is within the metric's bounds, if it has any
+func (c MetricConfig) inRange(value float64) bool {
+	return c.Range == nil || (value >= c.Range.Min && value <= c.Range.Max)
 }
 
 // metricRegistry maps metric type names to their configurations
 var metricRegistry = map[string]MetricConfig{
 	// Heart & Activity - TimeSeriesMetric
-	"hr":    {MetricType: "timeseries", Field: "last", DisplayName: "HEART RATE", Unit: "BPM", PrometheusName: "ultrahuman_heart_rate_bpm"},
+	"hr":    {MetricType: "timeseries", Field: "last", DisplayName: "HEART RATE", Unit: "BPM", PrometheusName: "ultrahuman_heart_rate_bpm", Range: &ValueRange{Min: 30, Max: 220}},
 	"hrv":   {MetricType: "timeseries", Field: "last", DisplayName: "HEART RATE VARIABILITY", Unit: "ms", PrometheusName: "ultrahuman_hrv_ms"},
-	"temp":  {MetricType: "timeseries", Field: "last", DisplayName: "SKIN TEMPERATURE", Unit: "°C", PrometheusName: "ultrahuman_skin_temperature_celsius"},
-	"spo2":  {MetricType: "timeseries", Field: "avg", DisplayName: "SPO2 (Blood Oxygen)", Unit: "%", PrometheusName: "ultrahuman_spo2_percent"},
-	"steps": {MetricType: "timeseries", Field: "total", DisplayName: "STEPS", Unit: "", PrometheusName: "ultrahuman_steps_total"},
+	"temp":  {MetricType: "timeseries", Field: "last", DisplayName: "SKIN TEMPERATURE", Unit: "°C", PrometheusName: "ultrahuman_skin_temperature_celsius", Decimals: 1},
+	"spo2":  {MetricType: "timeseries", Field: "avg", DisplayName: "SPO2 (Blood Oxygen)", Unit: "%", PrometheusName: "ultrahuman_spo2_percent", Range: &ValueRange{Min: 50, Max: 100}},
+	"steps": {MetricType: "timeseries", Field: "total", DisplayName: "STEPS", Unit: "", PrometheusName: "ultrahuman_steps_total", IsCounter: true},
+
+	// Motion - TimeSeriesMetric (display counts readings; push sends each raw value)
+	"motion": {MetricType: "timeseries", Field: "last", DisplayName: "MOTION", Unit: "", PrometheusName: "ultrahuman_motion"},
 
 	// Activity - SimpleMetric
-	"movement_index":  {MetricType: "simple", DisplayName: "MOVEMENT INDEX", Unit: "", PrometheusName: "ultrahuman_movement_index"},
-	"active_minutes":  {MetricType: "simple", DisplayName: "ACTIVE MINUTES", Unit: "min", PrometheusName: "ultrahuman_active_minutes"},
-	"recovery_index":  {MetricType: "simple", DisplayName: "RECOVERY INDEX", Unit: "", PrometheusName: "ultrahuman_recovery_index"},
-	"recovery":        {MetricType: "simple", DisplayName: "RECOVERY", Unit: "", PrometheusName: "ultrahuman_recovery"},
-	"vo2_max":         {MetricType: "simple", DisplayName: "VO2 MAX", Unit: "ml/kg/min", PrometheusName: "ultrahuman_vo2_max"},
+	"movement_index": {MetricType: "simple", DisplayName: "MOVEMENT INDEX", Unit: "", PrometheusName: "ultrahuman_movement_index"},
+	"active_minutes": {MetricType: "simple", DisplayName: "ACTIVE MINUTES", Unit: "min", PrometheusName: "ultrahuman_active_minutes"},
+	"recovery_index": {MetricType: "simple", DisplayName: "RECOVERY INDEX", Unit: "", PrometheusName: "ultrahuman_recovery_index"},
+	"recovery":       {MetricType: "simple", DisplayName: "RECOVERY", Unit: "", PrometheusName: "ultrahuman_recovery"},
+	"vo2_max":        {MetricType: "simple", DisplayName: "VO2 MAX", Unit: "ml/kg/min", PrometheusName: "ultrahuman_vo2_max", Decimals: 1},
 
 	// Temperature - SimpleMetric
-	"temperature_deviation":    {MetricType: "simple", DisplayName: "TEMPERATURE DEVIATION", Unit: "°C", PrometheusName: "ultrahuman_temperature_deviation_celsius"},
-	"average_body_temperature": {MetricType: "simple", DisplayName: "AVG BODY TEMP", Unit: "°C", PrometheusName: "ultrahuman_avg_body_temperature_celsius"},
+	"temperature_deviation":    {MetricType: "simple", DisplayName: "TEMPERATURE DEVIATION", Unit: "°C", PrometheusName: "ultrahuman_temperature_deviation_celsius", Decimals: 1},
+	"average_body_temperature": {MetricType: "simple", DisplayName: "AVG BODY TEMP", Unit: "°C", PrometheusName: "ultrahuman_avg_body_temperature_celsius", Decimals: 1},
 
 	// Sleep - SimpleMetric
 	"sleep_score":       {MetricType: "simple", DisplayName: "SLEEP SCORE", Unit: "", PrometheusName: "ultrahuman_sleep_score"},
@@ -179,13 +553,13 @@ var metricRegistry = map[string]MetricConfig{
 	"movements":         {MetricType: "simple", DisplayName: "MOVEMENTS (Sleep)", Unit: "", PrometheusName: "ultrahuman_sleep_movements"},
 
 	// Glucose - TimeSeriesMetric
-	"glucose": {MetricType: "timeseries", Field: "last", DisplayName: "GLUCOSE", Unit: "mg/dL", PrometheusName: "ultrahuman_glucose_mg_dl"},
+	"glucose": {MetricType: "timeseries", Field: "last", DisplayName: "GLUCOSE", Unit: "mg/dL", PrometheusName: "ultrahuman_glucose_mg_dl", Range: &ValueRange{Min: 20, Max: 600}},
 
 	// Glucose - SimpleMetric
 	"average_glucose":     {MetricType: "simple", DisplayName: "AVERAGE GLUCOSE", Unit: "mg/dL", PrometheusName: "ultrahuman_avg_glucose_mg_dl"},
-	"glucose_variability": {MetricType: "simple", DisplayName: "GLUCOSE VARIABILITY", Unit: "%", PrometheusName: "ultrahuman_glucose_variability_percent"},
+	"glucose_variability": {MetricType: "simple", DisplayName: "GLUCOSE VARIABILITY", Unit: "%", PrometheusName: "ultrahuman_glucose_variability_percent", Decimals: 1},
 	"time_in_target":      {MetricType: "simple", DisplayName: "TIME IN TARGET", Unit: "%", PrometheusName: "ultrahuman_time_in_target_percent"},
-	"hba1c":               {MetricType: "simple", DisplayName: "HbA1c (Estimated)", Unit: "%", PrometheusName: "ultrahuman_hba1c_percent"},
+	"hba1c":               {MetricType: "simple", DisplayName: "HbA1c (Estimated)", Unit: "%", PrometheusName: "ultrahuman_hba1c_percent", Decimals: 1},
 	"metabolic_score":     {MetricType: "simple", DisplayName: "METABOLIC SCORE", Unit: "", PrometheusName: "ultrahuman_metabolic_score"},
 }
 
@@ -200,71 +574,194 @@ func getLatestTimestamp(values []TimeValue) int64 {
 	return latest
 }
 
-// updateGlobalTimestamp updates the global timestamp if the new one is more recent
-func updateGlobalTimestamp(ts int64) {
-	if ts > globalLatestTimestamp {
-		globalLatestTimestamp = ts
+// largestGap returns the longest interval in seconds between consecutive
+// readings. Values may arrive unordered, so they are sorted first.
+func largestGap(values []TimeValue) int64 {
+	timestamps := make([]int64, len(values))
+	for i, v := range values {
+		timestamps[i] = v.Timestamp
 	}
-}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
 
-// pushMetrics pushes time series metrics via remote write with their original timestamps
-func pushMetrics(metrics []Metric, rwClient *RemoteWriteClient) error {
-	if rwClient == nil {
-		return nil
+	var gap int64
+	for i := 1; i < len(timestamps); i++ {
+		gap = max(gap, timestamps[i]-timestamps[i-1])
 	}
+	return gap
+}
 
-	var timeseries []prompb.TimeSeries
+// mean returns the arithmetic mean of values, or 0 when empty
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
 
-	lastPushedMu.Lock()
-	defer lastPushedMu.Unlock()
+// withLabel returns a copy of labels with name set to value
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	merged := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[name] = value
+	return merged
+}
 
-	for _, m := range metrics {
-		config, ok := metricRegistry[m.Type]
-		if !ok || config.PrometheusName == "" || config.MetricType != "timeseries" {
-			continue
+// readingEventsFor converts pushed series into /events payloads
+func readingEventsFor(timeseries []prompb.TimeSeries) []readingEvent {
+	var events []readingEvent
+	for _, ts := range timeseries {
+		var labels map[string]string
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				continue
+			}
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[l.Name] = l.Value
 		}
-
-		var v TimeSeriesMetric
-		if err := json.Unmarshal(m.Object, &v); err != nil {
-			continue
+		for _, sample := range ts.Samples {
+			events = append(events, readingEvent{
+				Metric:    seriesName(ts),
+				Labels:    labels,
+				Value:     sample.Value,
+				Timestamp: sample.Timestamp / 1000,
+			})
 		}
+	}
+	return events
+}
 
-		lastTs := lastPushedTimestamp[m.Type]
+// newAPIClient builds the shared client for Ultrahuman API requests so
+// connections are reused across fetches
+func newAPIClient(timeout time.Duration, proxy func(*http.Request) (*url.URL, error), userAgent string) *http.Client {
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		MaxIdleConns:          20,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeout,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &userAgentTransport{next: transport, userAgent: userAgent},
+	}
+}
 
-		// Steps: push daily total instead of cumulative readings
-		if m.Type == "steps" {
-			if v.DayStartTimestamp > lastTs {
-				timestampMs := v.DayStartTimestamp * 1000
-				timeseries = append(timeseries, buildTimeSeries(config.PrometheusName, v.Total, timestampMs))
-				lastPushedTimestamp[m.Type] = v.DayStartTimestamp
-				updateGlobalTimestamp(v.DayStartTimestamp)
+// makeRequest fetches and decodes one API response, retrying transient
+// failures (transport errors and 5xx) a couple of times with a short backoff
+func makeRequest(ctx context.Context, client *http.Client, baseURL string, params map[string]string, token string) (*APIResponse, error) {
+	var apiResp *APIResponse
+	var err error
+	for attempt := 0; attempt <= apiRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("API request failed (%v), retrying", err)
+			select {
+			case <-time.After(time.Duration(attempt) * apiRetryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			}
-			continue
 		}
-
-		// Push each individual reading with its timestamp
-		for _, reading := range v.Values {
-			if reading.Timestamp <= lastTs {
-				continue
-			}
-			timestampMs := reading.Timestamp * 1000
-			timeseries = append(timeseries, buildTimeSeries(config.PrometheusName, reading.Value, timestampMs))
-			if reading.Timestamp > lastPushedTimestamp[m.Type] {
-				lastPushedTimestamp[m.Type] = reading.Timestamp
-			}
-			updateGlobalTimestamp(reading.Timestamp)
+		if err := apiLimiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		apiResp, err = doRequest(ctx, client, baseURL, params, token)
+		if err == nil || ctx.Err() != nil || !isTransientAPIError(err) {
+			break
 		}
 	}
+	return apiResp, err
+}
 
-	if len(timeseries) == 0 {
-		return nil
+const (
+	apiRetries     = 2 // extra attempts after a transient failure
+	apiRetryDelay  = time.Second
+	maxBodySnippet = 200 // bytes of a bad response body quoted in errors
+)
+
+// apiError is a non-2xx response from the Ultrahuman API
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// isTransientAPIError reports whether a request may succeed if tried again:
+// transport errors and 5xx responses (e.g. a gateway timeout page)
+func isTransientAPIError(err error) bool {
+	if errors.Is(err, errUnauthorized) {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode/100 == 5
+	}
+	var decodeErr *apiDecodeError
+	return !errors.As(err, &decodeErr)
+}
+
+// apiDecodeError is a 2xx response whose body isn't a valid API envelope
+type apiDecodeError struct {
+	Err  error
+	Body string
+}
+
+func (e *apiDecodeError) Error() string {
+	return fmt.Sprintf("decoding API response: %v (body: %s)", e.Err, e.Body)
+}
+
+func (e *apiDecodeError) Unwrap() error { return e.Err }
+
+// bodySnippet returns the start of a response body for error messages
+func bodySnippet(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) > maxBodySnippet {
+		return string(body[:maxBodySnippet]) + "..."
+	}
+	return string(body)
+}
+
+// apiAuthScheme is prefixed to the token in the Authorization header
+// (--auth-scheme). Empty sends the raw token, which the partner API expects.
+var apiAuthScheme string
+
+func authorizationValue(token string) string {
+	if apiAuthScheme == "" {
+		return token
 	}
+	return apiAuthScheme + " " + token
+}
 
-	log.Printf("Pushing %d data points via remote write", len(timeseries))
-	return rwClient.Write(timeseries)
+// dumpRaw prints every API response body to stderr before it is decoded (--dump-raw)
+var dumpRaw bool
+
+// dumpResponse writes a response body unchanged, indented with --pretty when
+// it is valid JSON. Only the URL is shown of the request, never its headers,
+// so the token stays out of the dump.
+func dumpResponse(w io.Writer, requestURL string, status int, body []byte) {
+	fmt.Fprintf(w, "--- GET %s (%d)\n", requestURL, status)
+	var indented bytes.Buffer
+	if prettyJSON && json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	w.Write(body)
+	if len(body) == 0 || body[len(body)-1] != '\n' {
+		fmt.Fprintln(w)
+	}
 }
 
-func makeRequest(baseURL string, params map[string]string, token string) (*APIResponse, error) {
+// doRequest performs one API request and decodes the response envelope
+func doRequest(ctx context.Context, client *http.Client, baseURL string, params map[string]string, token string) (*APIResponse, error) {
 	u, _ := url.Parse(baseURL)
 	q := u.Query()
 	for key, value := range params {
@@ -272,31 +769,79 @@ func makeRequest(baseURL string, params map[string]string, token string) (*APIRe
 	}
 	u.RawQuery = q.Encode()
 
-	req, _ := http.NewRequest("GET", u.String(), nil)
-	req.Header.Add("Authorization", token)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	req.Header.Add("Authorization", authorizationValue(token))
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errUnauthorized
+	}
+
+	// The transport only decompresses gzip it asked for itself; a body that
+	// still carries Content-Encoding: gzip has to be unwrapped here
+	var reader io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing response body: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("reading response body: %w", err)
 	}
+	if dumpRaw {
+		dumpResponse(os.Stderr, u.String(), resp.StatusCode, body)
+	}
 
-	var apiResp APIResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, err
+	if resp.StatusCode/100 != 2 {
+		return nil, &apiError{StatusCode: resp.StatusCode, Body: bodySnippet(body)}
 	}
 
-	return &apiResp, nil
+	apiResp, err := activeEndpoint.decode(body)
+	if err != nil {
+		return nil, &apiDecodeError{Err: err, Body: bodySnippet(body)}
+	}
+	apiResp.raw = body
+
+	// Some failures arrive as HTTP 200 with an error status in the envelope
+	// and a null error; without metrics there is nothing usable to return
+	if apiResp.Error == nil && apiResp.Status != 0 && apiResp.Status/100 != 2 && len(apiResp.Data.Metrics) == 0 {
+		return nil, &apiError{StatusCode: apiResp.Status, Body: bodySnippet(body)}
+	}
+
+	if apiResp.Error == nil {
+		if err := reportShapeProblems(checkResponseShape(apiResp)); err != nil {
+			return nil, &apiDecodeError{Err: err, Body: bodySnippet(body)}
+		}
+	}
+
+	return apiResp, nil
 }
 
-func formatTimestamp(ts int64) string {
-	return time.Unix(ts, 0).Format("15:04")
+// loadLocation resolves the API's timezone name, falling back to UTC if it is empty or unknown
+func loadLocation(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unknown timezone %q, falling back to UTC", name)
+		return time.UTC
+	}
+	return loc
+}
+
+func formatTimestamp(ts int64, loc *time.Location) string {
+	return time.Unix(ts, 0).In(loc).Format("15:04")
 }
 
 func formatDuration(minutes float64) string {
@@ -308,11 +853,61 @@ func formatDuration(minutes float64) string {
 	return fmt.Sprintf("%dm", m)
 }
 
-func printSection(title string) {
-	fmt.Printf("\n  %s\n", title)
+// getMetricValue formats the value of metricType, "null" if the API returned
+// it without a value or "not found" if it is absent. Sleep stages missing as
+// standalone metrics are read from the composite sleep object instead.
+func getMetricValue(metrics []Metric, metricType string) string {
+	value := standaloneMetricValue(metrics, metricType)
+	if value == "not found" || value == "null" {
+		if stage, ok := sleepStageValue(metrics, metricType); ok {
+			return stage
+		}
+	}
+	return value
 }
 
-func getMetricValue(metrics []Metric, metricType string) string {
+// sleepStageFields maps CLI keys to their fields of the composite sleep object
+var sleepStageFields = map[string]func(*SleepMetric) *float64{
+	"sleep_score":      func(v *SleepMetric) *float64 { return v.Score },
+	"total_sleep":      func(v *SleepMetric) *float64 { return v.TotalSleep },
+	"sleep_efficiency": func(v *SleepMetric) *float64 { return v.Efficiency },
+	"time_in_bed":      func(v *SleepMetric) *float64 { return v.TimeInBed },
+	"deep_sleep":       func(v *SleepMetric) *float64 { return v.DeepSleep },
+	"light_sleep":      func(v *SleepMetric) *float64 { return v.LightSleep },
+	"rem_sleep":        func(v *SleepMetric) *float64 { return v.RemSleep },
+}
+
+// sleepStageValue formats a sleep stage from the composite sleep object. ok
+// is false if metricType isn't a stage or there is no sleep object; a stage
+// the object leaves out is "null".
+func sleepStageValue(metrics []Metric, metricType string) (string, bool) {
+	field, ok := sleepStageFields[metricType]
+	if !ok {
+		return "", false
+	}
+	for _, m := range metrics {
+		if m.Type != "sleep" {
+			continue
+		}
+		var v SleepMetric
+		if err := json.Unmarshal(m.Object, &v); err != nil {
+			return "null", true
+		}
+		value := field(&v)
+		if value == nil {
+			return "null", true
+		}
+		config := metricRegistry[metricType]
+		if config.IsDuration {
+			return formatDuration(*value), true
+		}
+		return config.formatValue(*value), true
+	}
+	return "", false
+}
+
+// standaloneMetricValue formats the value of the metricType entry itself
+func standaloneMetricValue(metrics []Metric, metricType string) string {
 	for _, m := range metrics {
 		if m.Type != metricType {
 			continue
@@ -347,7 +942,7 @@ func getMetricValue(metrics []Metric, metricType string) string {
 		switch config.MetricType {
 		case "timeseries":
 			var v TimeSeriesMetric
-			if err := json.Unmarshal(m.Object, &v); err != nil {
+			if err := json.Unmarshal(m.Object, &v); err != nil || !v.present() {
 				return "null"
 			}
 			var value float64
@@ -359,10 +954,7 @@ func getMetricValue(metrics []Metric, metricType string) string {
 			case "total":
 				value = v.Total
 			}
-			if config.Unit == "°C" {
-				return fmt.Sprintf("%.1f", value)
-			}
-			return fmt.Sprintf("%.0f", value)
+			return config.formatValue(value)
 
 		case "simple":
 			var v SimpleMetric
@@ -375,33 +967,68 @@ func getMetricValue(metrics []Metric, metricType string) string {
 			if config.IsDuration {
 				return formatDuration(*v.Value)
 			}
-			// Use decimal for temperature, percentages with decimals, and vo2_max
-			if config.Unit == "°C" || metricType == "hba1c" || metricType == "glucose_variability" || metricType == "vo2_max" {
-				return fmt.Sprintf("%.1f", *v.Value)
-			}
-			return fmt.Sprintf("%.0f", *v.Value)
+			return config.formatValue(*v.Value)
 		}
 	}
 	return "not found"
 }
 
-func displayMetrics(resp *APIResponse) {
-	fmt.Println("══════════════════════════════════════════════════════════")
-	fmt.Printf("  ULTRAHUMAN METRICS | Timezone: %s\n", resp.Data.LatestTimeZone)
-	fmt.Println("══════════════════════════════════════════════════════════")
+// displayMetrics renders the report for every date in the response to w,
+// with colored values when color is set and one line per metric when compact
+func displayMetrics(w io.Writer, resp *APIResponse, color, compact bool) {
+	loc := loadLocation(resp.Data.LatestTimeZone)
+	if compact {
+		for _, date := range resp.Data.sortedDates() {
+			if len(resp.Data.Metrics) > 1 {
+				fmt.Fprintf(w, "%s\n", date)
+			}
+			d := &display{color: color, compact: true}
+			for _, m := range displayOrder(resp.Data.Metrics[date]) {
+				displayMetric(d, m, loc)
+			}
+			d.flush(w)
+		}
+		return
+	}
 
-	for date, metrics := range resp.Data.Metrics {
-		fmt.Printf("\n  Date: %s\n", date)
-		fmt.Println("──────────────────────────────────────────────────────────")
+	fmt.Fprintln(w, "══════════════════════════════════════════════════════════")
+	fmt.Fprintf(w, "  ULTRAHUMAN METRICS | Timezone: %s\n", resp.Data.LatestTimeZone)
+	fmt.Fprintln(w, "══════════════════════════════════════════════════════════")
 
-		for _, m := range metrics {
-			displayMetric(m)
+	for _, date := range resp.Data.sortedDates() {
+		fmt.Fprintf(w, "\n  Date: %s\n", date)
+		fmt.Fprintln(w, "──────────────────────────────────────────────────────────")
+
+		d := &display{color: color}
+		for _, m := range displayOrder(resp.Data.Metrics[date]) {
+			displayMetric(d, m, loc)
 		}
+		d.flush(w)
 	}
-	fmt.Println("\n══════════════════════════════════════════════════════════")
+	fmt.Fprintln(w, "\n══════════════════════════════════════════════════════════")
 }
 
-func displayMetric(m Metric) {
+// displayOrder returns metrics sorted by display name, then type, so the
+// report doesn't depend on the order the API lists them in
+func displayOrder(metrics []Metric) []Metric {
+	name := func(m Metric) string {
+		if config, ok := metricRegistry[m.Type]; ok {
+			return config.DisplayName
+		}
+		return strings.ToUpper(m.Type) // the sleep composite shows as SLEEP
+	}
+	sorted := append([]Metric(nil), metrics...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if a, b := name(sorted[i]), name(sorted[j]); a != b {
+			return a < b
+		}
+		return sorted[i].Type < sorted[j].Type
+	})
+	return sorted
+}
+
+// displayMetric adds one metric section to d; metrics without data are skipped
+func displayMetric(d *display, m Metric, loc *time.Location) {
 	// Handle special "sleep" composite type
 	if m.Type == "sleep" {
 		var v SleepMetric
@@ -411,15 +1038,15 @@ func displayMetric(m Metric) {
 		if v.Score == nil && v.TotalSleep == nil {
 			return
 		}
-		printSection("SLEEP")
+		d.section("SLEEP")
 		if v.Score != nil {
-			fmt.Printf("      Score: %.0f\n", *v.Score)
+			d.field("Score", fmt.Sprintf("%.0f", *v.Score), "")
 		}
 		if v.TotalSleep != nil {
-			fmt.Printf("      Total: %s\n", formatDuration(*v.TotalSleep))
+			d.field("Total", formatDuration(*v.TotalSleep), "")
 		}
 		if v.Efficiency != nil {
-			fmt.Printf("      Efficiency: %.0f%%\n", *v.Efficiency)
+			d.field("Efficiency", fmt.Sprintf("%.0f", *v.Efficiency), "%")
 		}
 		return
 	}
@@ -430,8 +1057,8 @@ func displayMetric(m Metric) {
 		if err := json.Unmarshal(m.Object, &v); err != nil || len(v.Values) == 0 {
 			return
 		}
-		printSection("MOTION")
-		fmt.Printf("      Readings: %d\n", len(v.Values))
+		d.section("MOTION")
+		d.field("Readings", strconv.Itoa(len(v.Values)), "")
 		return
 	}
 
@@ -441,9 +1068,10 @@ func displayMetric(m Metric) {
 		if err := json.Unmarshal(m.Object, &v); err != nil {
 			return
 		}
-		if v.Total > 0 || v.Avg > 0 {
-			printSection("STEPS")
-			fmt.Printf("      Total: %.0f | Avg: %.0f\n", v.Total, v.Avg)
+		if v.present() {
+			d.section("STEPS")
+			d.field("Total", fmt.Sprintf("%.0f", v.Total), "")
+			d.field("Avg", fmt.Sprintf("%.0f", v.Avg), "")
 		}
 		return
 	}
@@ -460,10 +1088,10 @@ func displayMetric(m Metric) {
 		if err := json.Unmarshal(m.Object, &v); err != nil {
 			return
 		}
-		if v.Title == "" {
+		if !v.present() {
 			return
 		}
-		printSection(config.DisplayName)
+		d.section(config.DisplayName)
 		unit := config.Unit
 		if unit == "" {
 			unit = v.Unit
@@ -471,25 +1099,15 @@ func displayMetric(m Metric) {
 		// Print summary value
 		switch config.Field {
 		case "last":
-			if config.Unit == "°C" {
-				fmt.Printf("      Last: %.1f%s\n", v.LastReading, unit)
-			} else {
-				fmt.Printf("      Last: %.0f %s\n", v.LastReading, unit)
-			}
+			d.field("Last", config.formatValue(v.LastReading), unitSuffix(unit))
 		case "avg":
-			fmt.Printf("      Average: %.0f%s\n", v.Avg, unit)
+			d.field("Average", config.formatValue(v.Avg), unitSuffix(unit))
 		case "total":
-			fmt.Printf("      Total: %.0f\n", v.Total)
+			d.field("Total", config.formatValue(v.Total), "")
 		}
 		// Print individual time series values
 		for _, r := range v.Values {
-			if config.Unit == "°C" {
-				fmt.Printf("      - %.1f%s @ %s\n", r.Value, unit, formatTimestamp(r.Timestamp))
-			} else if config.Unit == "%" || m.Type == "spo2" {
-				fmt.Printf("      - %.0f%% @ %s\n", r.Value, formatTimestamp(r.Timestamp))
-			} else {
-				fmt.Printf("      - %.0f %s @ %s\n", r.Value, unit, formatTimestamp(r.Timestamp))
-			}
+			d.reading(config.formatValue(r.Value), unitSuffix(unit), formatTimestamp(r.Timestamp, loc))
 		}
 
 	case "simple":
@@ -497,19 +1115,13 @@ func displayMetric(m Metric) {
 		if err := json.Unmarshal(m.Object, &v); err != nil || v.Value == nil {
 			return
 		}
-		printSection(config.DisplayName)
+		d.section(config.DisplayName)
 		if config.IsDuration {
-			fmt.Printf("      Duration: %s\n", formatDuration(*v.Value))
-		} else if config.Unit == "°C" {
-			fmt.Printf("      Value: %.1f%s\n", *v.Value, config.Unit)
-		} else if config.Unit == "%" {
-			fmt.Printf("      Value: %.0f%%\n", *v.Value)
-		} else if config.Unit == "ml/kg/min" || m.Type == "hba1c" || m.Type == "glucose_variability" {
-			fmt.Printf("      Value: %.1f %s\n", *v.Value, config.Unit)
+			d.field("Duration", formatDuration(*v.Value), "")
 		} else if config.Unit != "" {
-			fmt.Printf("      Value: %.0f %s\n", *v.Value, config.Unit)
+			d.field("Value", config.formatValue(*v.Value), unitSuffix(config.Unit))
 		} else {
-			fmt.Printf("      Score: %.0f\n", *v.Value)
+			d.field("Score", config.formatValue(*v.Value), "")
 		}
 	}
 }
@@ -518,15 +1130,111 @@ func printUsage() {
 	fmt.Println(`Usage: uh-ring [options] [command]
 
 Options:
+  --config <path>           YAML config file (flags > env vars > config file > defaults)
+  --print-config            Print the resolved settings as JSON (secrets redacted) and exit
   --api-token <token>       API token (or set ULTRAHUMAN_API_TOKEN env var)
+  --api-token-file <path>   Read the API token from a file (e.g. a mounted secret)
+  --interval-jitter <frac>  Randomize each interval by ±frac (e.g. 0.1 for ±10%)
+  --proxy-url <url>         Proxy for API and export requests (default: HTTP(S)_PROXY env)
+  --auth-scheme <scheme>    Authorization scheme for the API token: none or Bearer (default: none)
+  --user-agent <ua>         User-Agent for outgoing requests (default: uh-ring-stats/<version>)
+  --api-timeout <duration>  Ultrahuman API request timeout (default: 30s)
+  --endpoint <name>         Partner API endpoint to fetch: daily_metrics (default)
+  --api-url <url>           Endpoint URL override, e.g. a mock-server (default: the partner API)
+  --api-rate-limit <n>      Maximum API requests per minute across all accounts (default: unlimited)
+  --api-since-param <name>  Send the newest fetched timestamp as this query parameter to
+                            fetch only newer readings (requires API support)
+  --strict                  Fail fetches on unexpected API response shapes (default: log them once)
+  --listen-address <addr>   Interface to bind, e.g. 127.0.0.1 (default: all interfaces)
   --port <port>             Port for Prometheus server (default: 8080)
-  --interval <seconds>      Metric refresh interval in seconds (default: 60)
+  --tls-cert-file <path>, --tls-key-file <path>
+                            Serve all endpoints over HTTPS with this certificate
+  --web-username <user>, --web-password <pass>
+                            Require basic auth on every endpoint except /health
+  --metrics-listen <addr>   Serve ring data /metrics on a separate address
+  --telemetry-listen <addr> Serve the exporter's own metrics on a separate address
+  --interval <duration>     Metric refresh interval, e.g. 5m; bare numbers are seconds (default: 60)
+  --timezone <zone>         IANA timezone whose day is fetched (default: UTC)
+  --date <day>              Day to show, or the last day of backfill: YYYY-MM-DD, today,
+                            yesterday or -Nd (default: today in --timezone)
   --remote-write-url <url>  Prometheus remote write URL for historical data
                             (e.g., http://localhost:9090/api/v1/write)
+  --remote-write-username <user>, --remote-write-password <pass>
+                            Basic auth for remote write
+  --grafana-cloud-url <url> Grafana Cloud remote write preset; with --grafana-cloud-user
+                            (instance ID) and --grafana-cloud-token
+  --remote-write-fallback-url <url>
+                            Secondary remote write URL used when the primary fails
+  --remote-write-timeout <duration>
+                            Remote write request timeout, separate from --api-timeout (default: 30s)
+  --remote-write-max-idle-conns <n>
+                            Idle keep-alive connections kept to the receiver (default: 2)
+  --remote-write-idle-timeout <duration>
+                            Close idle remote write connections after this long (default: 90s)
+  --remote-write-encoding <enc>
+                            Remote write compression: snappy or zstd (default: snappy)
+  --exporter <name>         Export backend: remote-write (default), pushgateway, victoriametrics, graphite,
+                            file or none (pull endpoints only)
+  --pushgateway-url <url>   Pushgateway URL (e.g., http://localhost:9091)
+  --pushgateway-job <job>   Pushgateway job name (default: uh-ring)
+  --pushgateway-instance <name>  Pushgateway instance label (default: hostname)
+  --days <n>                Days to fetch with backfill (default: 7)
+  --concurrency <n>         Parallel day fetches during backfill (default: 4)
+  --account token=<t>,label=<l>  Additional ring account (repeatable); series get an account label
+  --unhealthy-after <duration>  Fail /ready after this long without a successful fetch
+                            (default: 3x interval)
+  --down-after <n>          Consecutive failed fetches before ultrahuman_up is 0 (default: 3)
+  --spool-dir <dir>         Buffer failed pushes on disk and replay them later
+  --spool-max-bytes <n>     Spool size cap, oldest batches dropped first (default: 100MB)
+  --vm-url <url>            VictoriaMetrics base URL (e.g., http://localhost:8428)
+  --out-file <path>         File the file exporter writes the latest metrics to
+  --textfile-dir <dir>      Write ultrahuman.prom for node_exporter's textfile collector every cycle
+  --graphite-address <host:port>  Carbon plaintext endpoint (e.g., localhost:2003)
+  --graphite-prefix <prefix>     Graphite path prefix (default: ultrahuman)
+  --batch-size <n>          Maximum series per remote write request (default: 500)
+  --remote-write-ca-file <path>    CA certificate for the remote write endpoint
+  --remote-write-cert-file <path>  Client certificate for mutual TLS
+  --remote-write-key-file <path>   Client key for mutual TLS
+  --remote-write-insecure   Skip TLS certificate verification for remote write
+  --once                    With serve, fetch and push a single cycle then exit
+  --output <format>         CLI output format: text (default) or json
+  --pretty                  Indent JSON output
+  --dump-raw                Print each raw API response body to stderr (indented with --pretty)
+  --compact                 One line per metric ("HEART RATE: 62 BPM (last @ 14:32)")
+  --color <when>            Color the text report: auto (default), always or never;
+                            auto respects NO_COLOR and stays plain when piped
+  --remote-read             Serve recently pushed samples on /read (Prometheus remote read)
+  --alert-webhook-url URL   POST JSON alerts here when a threshold rule fires
+  --metric-prefix <prefix>  Prefix replacing ultrahuman_ in series names (default: ultrahuman_)
+  --passthrough-unknown     Push unregistered metric types as ultrahuman_raw_<type>
+  --max-samples-per-metric <n>
+                            Push only the newest n samples per metric each cycle
+  --keep-going              Push each metric type separately; log and skip failures
+  --fail-fast               Push everything in one request; any failure fails the cycle (default)
+  --record-dir <dir>        Archive each raw API response fetched in serve mode
+  --record-gzip             Gzip recorded responses
+  --record-retention <dur>  Delete recordings older than this (e.g. 720h or 2592000; 0 keeps all)
+  --replay-file <path>      Answer API requests from a saved response file (offline testing)
+  --max-sample-age <dur>    Drop samples older than this before pushing (e.g. 1h or 3600; 0 keeps all)
+  --no-individual-readings  Push only each time series' summary (last/avg/total) at its
+                            newest reading instead of every reading
+  --sample-timestamp-mode <mode>
+                            Stamp samples with the reading time (reading, default) or the
+                            push time (ingest, newest value per series only)
+  --dry-run                 Log the series that would be pushed instead of sending them
+  --quiet                   Suppress the per-cycle push log; errors are still logged
 
 Commands:
   (no command)          Show all metrics
   serve                 Start Prometheus metrics server
+  dashboard             Live terminal view refreshed every --interval (q to quit)
+  backfill              Fetch and push the last --days days, then exit
+  metrics, list          List supported metrics with their Prometheus names
+  version               Print version, commit and build date (also --version)
+  check                 Verify the API token and connectivity (non-zero exit on failure)
+  diff --from D --to D  Compare daily summary metrics between two dates (YYYY-MM-DD)
+  freshness --metric M [--max-age 15m]
+                        Exit 1 if the newest reading of M is older than --max-age
 
   Heart & Activity:
     hr                  Heart rate (BPM)
@@ -573,80 +1281,242 @@ Commands:
     metabolic_score     Metabolic score`)
 }
 
-func fetchAndPushMetrics(baseURL, token string, rwClient *RemoteWriteClient) error {
-	dateParams := map[string]string{
-		"date": time.Now().Format("2006-01-02"),
+// metricInfo describes a registry entry for the metrics subcommand
+type metricInfo struct {
+	Key            string `json:"key"`
+	DisplayName    string `json:"display_name"`
+	Unit           string `json:"unit"`
+	MetricType     string `json:"metric_type"`
+	PrometheusName string `json:"prometheus_name"`
+}
+
+// listMetrics prints every registered metric sorted by key, as a table or JSON
+func listMetrics(w io.Writer, output string) {
+	keys := make([]string, 0, len(metricRegistry))
+	for key := range metricRegistry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	infos := make([]metricInfo, len(keys))
+	for i, key := range keys {
+		config := metricRegistry[key]
+		infos[i] = metricInfo{
+			Key:            key,
+			DisplayName:    config.DisplayName,
+			Unit:           config.Unit,
+			MetricType:     config.MetricType,
+			PrometheusName: config.PrometheusName,
+		}
+	}
+
+	if output == "json" {
+		newJSONEncoder(w).Encode(infos)
+		return
 	}
 
-	resp, err := makeRequest(baseURL, dateParams, token)
-	if err != nil {
-		return err
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tDISPLAY NAME\tUNIT\tTYPE\tPROMETHEUS NAME")
+	for _, info := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", info.Key, info.DisplayName, info.Unit, info.MetricType, info.PrometheusName)
 	}
+	tw.Flush()
+}
 
+// runCheck verifies the token and API connectivity with a single request and
+// returns the process exit code
+func runCheck(client *http.Client, token, date string) int {
+	resp, err := makeRequest(context.Background(), client, apiURL, activeEndpoint.params(date), token)
+	if errors.Is(err, errUnauthorized) {
+		fmt.Println("Auth: FAILED (401 Unauthorized)")
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("Connectivity: FAILED (%v)\n", err)
+		return 1
+	}
 	if resp.Error != nil {
-		return fmt.Errorf("API error: %s", *resp.Error)
+		fmt.Printf("Auth: OK\nAPI Error: %s\n", *resp.Error)
+		return 1
 	}
 
+	types := make(map[string]bool)
 	for _, metrics := range resp.Data.Metrics {
-		if err := pushMetrics(metrics, rwClient); err != nil {
-			return fmt.Errorf("push metrics: %w", err)
+		for _, m := range metrics {
+			types[m.Type] = true
 		}
-		break
 	}
+	fmt.Println("Auth: OK")
+	fmt.Printf("Metric types returned: %d\n", len(types))
+	return 0
+}
 
-	return nil
+// fetchAndPushMetrics fetches today's data for one account, caches it for
+// /metrics and pushes the new readings
+func fetchAndPushMetrics(fetcher *Fetcher, exporter Exporter) error {
+	label := fetcher.account.Label
+	date := fetcher.cfg.today()
+	resp, err := fetcher.Fetch(context.Background(), date)
+	if err != nil {
+		markStale(label)
+		return err
+	}
+	if label != "" {
+		date = label + "-" + date
+	}
+	recordResponse(fetcher.cfg, date, resp.raw)
+
+	cacheResponse(label, resp)
+	return fetcher.Push(resp, exporter)
+}
+
+// fetchAllAccounts fetches and pushes each configured account in turn
+func fetchAllAccounts(fetchers []*Fetcher, exporter Exporter) error {
+	var errs []error
+	for _, fetcher := range fetchers {
+		if err := fetchAndPushMetrics(fetcher, exporter); err != nil {
+			if label := fetcher.account.Label; label != "" {
+				err = fmt.Errorf("account %s: %w", label, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func startMetricsPusher(token string, port int, interval int, remoteWriteURL string) {
-	baseURL := "https://partner.ultrahuman.com/api/v1/partner/daily_metrics"
+// runFetchCycle fetches all accounts and records the outcome for /ready and /status
+func runFetchCycle(cfg *Config, fetchers []*Fetcher, exporter Exporter) error {
+	err := fetchAllAccounts(fetchers, exporter)
+	pushCounters.observeFetch()
 
-	if remoteWriteURL == "" {
-		log.Fatal("--remote-write-url is required for serve mode")
+	fetchStatusMu.Lock()
+	fetchCount++
+	initialFetchDone = true
+	if err != nil {
+		lastFetchError = err.Error()
+		consecutiveFailures++
+	} else {
+		lastSuccessfulFetch = time.Now()
+		consecutiveFailures = 0
 	}
+	fetchStatusMu.Unlock()
 
-	rwClient := NewRemoteWriteClient(remoteWriteURL)
-	log.Printf("Remote write target: %s", remoteWriteURL)
+	if cfg.TextfileDir != "" {
+		if err := writeTextfile(cfg); err != nil {
+			log.Printf("Writing textfile: %v", err)
+		}
+	}
+	return err
+}
 
-	// Initial fetch
-	if err := fetchAndPushMetrics(baseURL, token, rwClient); err != nil {
-		log.Printf("Initial fetch error: %v", err)
+// jitteredInterval randomizes interval uniformly within ±jitter (a fraction
+// of the interval) so a fleet of instances doesn't hit the API in lockstep
+func jitteredInterval(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	jitter = min(jitter, 1)
+	offset := (rand.Float64()*2 - 1) * jitter * float64(interval)
+	return interval + time.Duration(offset)
+}
+
+func startMetricsPusher(cfg *Config, client *http.Client) {
+	fetchers := newFetchers(cfg, client, apiURL)
+
+	// Load the certificate up front so a bad pair fails before any fetch
+	serverTLS, err := newServerTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Start background pusher
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.TextfileDir != "" {
+		if err := checkTextfileDir(cfg.TextfileDir); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Writing %s to %s every cycle", textfileName, cfg.TextfileDir)
+	}
+
+	cfg.logTimezone()
+	if len(cfg.Alerts) > 0 {
+		alerts = newAlertEvaluator(cfg.AlertWebhookURL, cfg.Alerts, client)
+	}
+
+	// Single cycle for cron-style scheduling
+	if cfg.Once {
+		if err := runFetchCycle(cfg, fetchers, exporter); err != nil {
+			log.Printf("Fetch error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, srv := range newListenerServers(cfg) {
+		srv.TLSConfig = serverTLS
+		go serveExtra(srv)
+	}
+
+	// Listen before the initial fetch so probes can reach /status while it
+	// runs; it reports initial_fetch_complete once the outcome is recorded
+	srv := newServer(cfg, fetchers)
+	srv.TLSConfig = serverTLS
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scheme := "http"
+	if srv.TLSConfig != nil {
+		scheme = "https"
+	}
+	log.Printf("Starting metrics pusher %s on %s://%s", versionString(), scheme, listener.Addr())
+	log.Printf("Pushing metrics every %d seconds", cfg.Interval)
 	go func() {
-		ticker := time.NewTicker(time.Duration(interval) * time.Second)
-		for range ticker.C {
-			if err := fetchAndPushMetrics(baseURL, token, rwClient); err != nil {
-				log.Printf("Fetch error: %v", err)
-			}
+		if srv.TLSConfig != nil {
+			log.Fatal(srv.ServeTLS(listener, "", ""))
 		}
+		log.Fatal(srv.Serve(listener))
 	}()
 
-	// Simple health endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "ok\n")
-	})
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"status":"running","last_data_timestamp":%d,"interval_seconds":%d}`, globalLatestTimestamp, interval)
-	})
+	if err := runFetchCycle(cfg, fetchers, exporter); err != nil {
+		log.Printf("Initial fetch error: %v", err)
+	}
 
-	addr := fmt.Sprintf(":%d", port)
-	log.Printf("Starting metrics pusher on %s", addr)
-	log.Printf("Pushing metrics every %d seconds", interval)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	interval := time.Duration(cfg.Interval) * time.Second
+	for {
+		time.Sleep(jitteredInterval(interval, cfg.IntervalJitter))
+		if err := runFetchCycle(cfg, fetchers, exporter); err != nil {
+			log.Printf("Fetch error: %v", err)
+		}
+	}
 }
 
 func main() {
-	apiToken := flag.String("api-token", "", "API token for Ultrahuman")
-	port := flag.Int("port", 8080, "Port for Prometheus server")
-	interval := flag.Int("interval", 60, "Metric refresh interval in seconds")
-	remoteWriteURL := flag.String("remote-write-url", "", "Prometheus remote write URL (e.g., http://localhost:9090/api/v1/write)")
-	flag.Usage = printUsage
-	flag.Parse()
+	cfg, args, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	args := flag.Args()
+	if cfg.PrintConfig {
+		if err := cfg.printConfig(os.Stdout); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Hidden: a fake API for development, no token needed
+	if len(args) > 0 && args[0] == "mock-server" {
+		os.Exit(runMockServer(args[1:]))
+	}
+
+	if cfg.ShowVersion || (len(args) > 0 && args[0] == "version") {
+		fmt.Println(versionString())
+		return
+	}
 
 	// Allow help without token
 	if len(args) > 0 && args[0] == "help" {
@@ -654,29 +1524,63 @@ func main() {
 		return
 	}
 
-	// Get token from flag or environment variable
-	token := *apiToken
-	if token == "" {
-		token = os.Getenv("ULTRAHUMAN_API_TOKEN")
+	if len(args) > 0 && (args[0] == "metrics" || args[0] == "list") {
+		listMetrics(os.Stdout, cfg.Output)
+		return
+	}
+
+	token := cfg.APIToken
+	if token == "" && len(cfg.Accounts) > 0 {
+		token = cfg.Accounts[0].Token
+	}
+	if token == "" && cfg.ReplayFile != "" {
+		token = "replay"
 	}
 	if token == "" {
-		fmt.Println("Error: API token required. Use --api-token or set ULTRAHUMAN_API_TOKEN env var")
+		fmt.Println("Error: API token required. Use --api-token, --api-token-file or set ULTRAHUMAN_API_TOKEN env var")
 		os.Exit(1)
 	}
 
-	// Handle serve command
-	if len(args) > 0 && args[0] == "serve" {
-		startMetricsPusher(token, *port, *interval, *remoteWriteURL)
+	proxy, err := proxyFunc(cfg.ProxyURL)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	apiClient := newAPIClient(time.Duration(cfg.APITimeout)*time.Second, proxy, cfg.UserAgent)
+	if cfg.ReplayFile != "" {
+		apiClient.Transport = &replayTransport{path: cfg.ReplayFile}
+	}
+
+	if len(args) > 0 && args[0] == "check" {
+		os.Exit(runCheck(apiClient, token, cfg.queryDate()))
+	}
+
+	if len(args) > 0 && args[0] == "diff" {
+		os.Exit(runDiff(apiClient, token, args[1:], cfg.Output))
+	}
+
+	if len(args) > 0 && args[0] == "freshness" {
+		os.Exit(runFreshness(cfg, apiClient, token, args[1:]))
+	}
+
+	if len(args) > 0 && args[0] == "backfill" {
+		if err := runBackfill(cfg, apiClient); err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
 		return
 	}
 
-	baseURL := "https://partner.ultrahuman.com/api/v1/partner/daily_metrics"
+	if len(args) > 0 && args[0] == "dashboard" {
+		os.Exit(runDashboard(cfg, apiClient))
+	}
 
-	dateParams := map[string]string{
-		"date": time.Now().Format("2006-01-02"),
+	// Handle serve command
+	if len(args) > 0 && args[0] == "serve" {
+		startMetricsPusher(cfg, apiClient)
+		return
 	}
 
-	resp, err := makeRequest(baseURL, dateParams, token)
+	resp, err := makeRequest(context.Background(), apiClient, apiURL, activeEndpoint.params(cfg.queryDate()), token)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -687,18 +1591,101 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Get metrics for today
-	var metrics []Metric
-	for _, m := range resp.Data.Metrics {
-		metrics = m
-		break
+	// Get metrics for the newest day; around midnight the API may return two
+	var latestDate string
+	for date := range resp.Data.Metrics {
+		if date > latestDate {
+			latestDate = date
+		}
 	}
+	metrics := resp.Data.Metrics[latestDate]
 
 	if len(args) < 1 {
-		displayMetrics(resp)
+		if cfg.Output == "json" {
+			printMetricValues(metrics, availableMetrics(metrics), cfg.Output)
+			return
+		}
+		displayMetrics(os.Stdout, resp, cfg.colorEnabled(os.Stdout), cfg.Compact)
 		return
 	}
 
-	value := getMetricValue(metrics, args[0])
-	fmt.Println(value)
+	if len(args) == 1 && cfg.Output != "json" {
+		value := getMetricValue(metrics, args[0])
+		// Keep stdout clean for scripts: missing values go to stderr with a distinct exit code
+		switch value {
+		case "not found":
+			fmt.Fprintln(os.Stderr, value)
+			os.Exit(2)
+		case "null":
+			fmt.Fprintln(os.Stderr, value)
+			os.Exit(3)
+		}
+		fmt.Println(value)
+		return
+	}
+
+	printMetricValues(metrics, args, cfg.Output)
+}
+
+// availableMetrics lists the queryable metric types present in metrics,
+// including sleep stages only found in the composite sleep object
+func availableMetrics(metrics []Metric) []string {
+	var names []string
+	present := make(map[string]bool)
+	for _, m := range metrics {
+		if _, ok := metricRegistry[m.Type]; ok || m.Type == "sleep" {
+			names = append(names, m.Type)
+			present[m.Type] = true
+		}
+	}
+	if !present["sleep"] {
+		return names
+	}
+	stages := make([]string, 0, len(sleepStageFields))
+	for key := range sleepStageFields {
+		if value, ok := sleepStageValue(metrics, key); ok && !present[key] && value != "null" {
+			stages = append(stages, key)
+		}
+	}
+	sort.Strings(stages)
+	return append(names, stages...)
+}
+
+// prettyJSON indents the CLI's JSON output (--pretty)
+var prettyJSON bool
+
+// newJSONEncoder returns the encoder for --output json, indented with --pretty
+func newJSONEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	if prettyJSON {
+		enc.SetIndent("", "  ")
+	}
+	return enc
+}
+
+// printMetricValues prints several metrics as "name: value" lines or, with
+// --output json, a single object where missing values are null. Unknown
+// metrics are reported on stderr without aborting the others.
+func printMetricValues(metrics []Metric, names []string, output string) {
+	values := make(map[string]*string, len(names))
+	for _, name := range names {
+		value := getMetricValue(metrics, name)
+		if value == "not found" {
+			fmt.Fprintf(os.Stderr, "Error: metric %q not found\n", name)
+		}
+
+		if output == "json" {
+			if value != "not found" && value != "null" {
+				values[name] = &value
+			} else {
+				values[name] = nil
+			}
+			continue
+		}
+		fmt.Printf("%s: %s\n", name, value)
+	}
+
+	if output == "json" {
+		newJSONEncoder(os.Stdout).Encode(values)
+	}
 }
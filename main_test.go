@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestFormatTimestampZone(t *testing.T) {
+	const ts = 1704063600 // 2023-12-31 23:00 UTC
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"", "23:00"},
+		{"UTC", "23:00"},
+		{"Asia/Kolkata", "04:30"},
+		{"America/Los_Angeles", "15:00"},
+		{"Not/AZone", "23:00"}, // unknown zones fall back to UTC
+	}
+	for _, tt := range tests {
+		if got := formatTimestamp(ts, loadLocation(tt.zone)); got != tt.want {
+			t.Errorf("formatTimestamp in %q = %s, want %s", tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestDisplayUsesLatestTimeZone(t *testing.T) {
+	render := func(zone string) string {
+		resp := &APIResponse{Data: Data{LatestTimeZone: zone, Metrics: map[string][]Metric{
+			"2023-12-31": {{Type: "hr", Object: []byte(`{"title":"Heart Rate","last_reading":60,"values":[{"value":60,"timestamp":1704063600}]}`)}},
+		}}}
+		var buf bytes.Buffer
+		displayMetrics(&buf, resp, false, false)
+		return buf.String()
+	}
+	if utc := render("UTC"); !strings.Contains(utc, "23:00") {
+		t.Errorf("UTC display lacks 23:00:\n%s", utc)
+	}
+	if kolkata := render("Asia/Kolkata"); !strings.Contains(kolkata, "04:30") || strings.Contains(kolkata, "23:00") {
+		t.Errorf("Asia/Kolkata display should show 04:30, not 23:00:\n%s", kolkata)
+	}
+}
+
+func TestSimpleMetricValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		object  string
+		want    *float64 // nil: no value
+		wantErr bool
+	}{
+		{"number", `{"value":72}`, ptr(72), false},
+		{"quoted", `{"value":"72.5"}`, ptr(72.5), false},
+		{"quoted with spaces", `{"value":" 72 "}`, ptr(72), false},
+		{"zero", `{"value":0}`, ptr(0), false},
+		{"quoted zero", `{"value":"0"}`, ptr(0), false},
+		{"empty string", `{"value":""}`, nil, false},
+		{"null", `{"value":null}`, nil, false},
+		{"missing", `{"title":"Recovery"}`, nil, false},
+		{"invalid string", `{"value":"high"}`, nil, true},
+		{"invalid type", `{"value":true}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m SimpleMetric
+			err := json.Unmarshal([]byte(tt.object), &m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			switch {
+			case tt.want == nil && m.Value != nil:
+				t.Errorf("Value = %g, want nil", *m.Value)
+			case tt.want != nil && (m.Value == nil || *m.Value != *tt.want):
+				t.Errorf("Value = %v, want %g", m.Value, *tt.want)
+			}
+		})
+	}
+}
+
+func ptr(v float64) *float64 { return &v }
+
+const stubResponse = `{"status":200,"error":null,"data":{"metrics":{"2024-01-01":[{"type":"sleep_score","object":{"value":80,"day_start_timestamp":1704067200}}]}}}`
+
+func TestGzipResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(stubResponse))
+		gz.Close()
+	}))
+	defer server.Close()
+
+	clients := map[string]*http.Client{
+		"transport decompresses": newAPIClient(5*time.Second, nil, "test"),
+		// Without transport compression the body still arrives gzipped
+		"decoded by doRequest": {Transport: &http.Transport{DisableCompression: true}},
+	}
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			resp, err := doRequest(context.Background(), client, server.URL, dateParams("2024-01-01"), "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := getMetricValue(resp.Data.Metrics["2024-01-01"], "sleep_score"); got != "80" {
+				t.Errorf("sleep_score = %q, want 80", got)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteEncodingRoundTrip(t *testing.T) {
+	for _, encoding := range []string{"snappy", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			var got prompb.WriteRequest
+			var contentEncoding string
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentEncoding = r.Header.Get("Content-Encoding")
+				body, _ := io.ReadAll(r.Body)
+				var data []byte
+				var err error
+				switch contentEncoding {
+				case "snappy":
+					data, err = snappy.Decode(nil, body)
+				case "zstd":
+					var decoder *zstd.Decoder
+					if decoder, err = zstd.NewReader(nil); err == nil {
+						data, err = decoder.DecodeAll(body, nil)
+						decoder.Close()
+					}
+				}
+				if err == nil {
+					err = got.Unmarshal(data)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+			}))
+			defer receiver.Close()
+
+			client := NewRemoteWriteClient(receiver.URL, nil)
+			if err := client.setEncoding(encoding); err != nil {
+				t.Fatal(err)
+			}
+			sent := []prompb.TimeSeries{
+				buildTimeSeries("ultrahuman_heart_rate_bpm", 61, 1704067300000, map[string]string{"account": "me"}),
+				buildTimeSeries("ultrahuman_sleep_score", 80, 1704067200000, nil),
+			}
+			if err := client.Write(sent); err != nil {
+				t.Fatal(err)
+			}
+
+			if contentEncoding != encoding {
+				t.Errorf("Content-Encoding = %q, want %q", contentEncoding, encoding)
+			}
+			if len(got.Timeseries) != len(sent) {
+				t.Fatalf("receiver decoded %d series, want %d", len(got.Timeseries), len(sent))
+			}
+			for i, ts := range got.Timeseries {
+				if seriesName(ts) != seriesName(sent[i]) || ts.Samples[0].Value != sent[i].Samples[0].Value || ts.Samples[0].Timestamp != sent[i].Samples[0].Timestamp {
+					t.Errorf("series %d = %s %v, want %s %v", i, seriesName(ts), ts.Samples, seriesName(sent[i]), sent[i].Samples)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizationHeader(t *testing.T) {
+	defer func(previous string) { apiAuthScheme = previous }(apiAuthScheme)
+
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.Write([]byte(stubResponse))
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct{ scheme, want string }{
+		{"", "secret-token"},
+		{"Bearer", "Bearer secret-token"},
+	} {
+		apiAuthScheme = tt.scheme
+		if _, err := doRequest(context.Background(), server.Client(), server.URL, dateParams("2024-01-01"), "secret-token"); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("scheme %q: Authorization = %q, want %q", tt.scheme, got, tt.want)
+		}
+	}
+}
+
+func TestEnvelopeStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int    // apiError status from doRequest, 0 for none
+		wantErr    string // error from Fetch
+	}{
+		{"500 with null error", `{"status":500,"error":null,"data":{"metrics":null}}`, 500, ""},
+		{"200 with error", `{"status":200,"error":"date out of range","data":{"metrics":{}}}`, 0, "API error: date out of range"},
+		{"500 with metrics", `{"status":500,"error":null,"data":{"metrics":{"2024-01-01":[]}}}`, 0, ""},
+		{"200", stubResponse, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			_, err := doRequest(context.Background(), server.Client(), server.URL, dateParams("2024-01-01"), "token")
+			var apiErr *apiError
+			if tt.wantStatus != 0 {
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus {
+					t.Fatalf("doRequest err = %v, want an apiError with status %d", err, tt.wantStatus)
+				}
+				return // makeRequest would retry it as a 5xx
+			}
+
+			f := newFetcher(defaultConfig(), server.Client(), server.URL, Account{})
+			_, err = f.Fetch(context.Background(), "2024-01-01")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Fetch err = %v, want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Fetch err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
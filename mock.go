@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// runMockServer implements the hidden mock-server subcommand: it serves
+// plausible daily_metrics responses so serve, backfill and the display can be
+// exercised without a ring. Point the tool at it with --api-url.
+func runMockServer(args []string) int {
+	fs := flag.NewFlagSet("mock-server", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:8099", "Address to serve the mock API on")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/partner/daily_metrics", handleMockDailyMetrics)
+	log.Printf("Mock API on http://%s/api/v1/partner/daily_metrics", *listen)
+	log.Printf("Use: --api-url http://%s/api/v1/partner/daily_metrics --api-token mock", *listen)
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// handleMockDailyMetrics answers one date (UTC, default today). Readings are
+// seeded by the date, so every request returns the same values and a day only
+// grows as time passes, like the real API.
+func handleMockDailyMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().UTC().Format("2006-01-02")
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"data": nil, "error": "invalid date", "status": 400})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"data": map[string]any{
+			"metrics":          map[string]any{date: mockMetrics(day, time.Now())},
+			"latest_time_zone": "UTC",
+		},
+		"error":  nil,
+		"status": 200,
+	})
+}
+
+// mockMetrics generates the metrics of day with readings up to now
+func mockMetrics(day, now time.Time) []map[string]any {
+	start := day.Unix()
+	end := min(now.Unix(), day.AddDate(0, 0, 1).Unix()-1)
+
+	// series draws one reading per step around base, following the time of day
+	series := func(metricType, title, unit string, step int64, base, swing, noise float64) map[string]any {
+		rng := mockRand(day, metricType)
+		var values []map[string]any
+		var sum, last float64
+		for ts := start; ts <= end; ts += step {
+			hour := float64(ts-start) / 3600
+			v := base + swing*math.Sin((hour-9)/24*2*math.Pi) + noise*rng.NormFloat64()
+			v = math.Round(v*10) / 10
+			values = append(values, map[string]any{"value": v, "timestamp": ts})
+			sum += v
+			last = v
+		}
+		object := map[string]any{"title": title, "unit": unit, "values": values, "last_reading": last, "day_start_timestamp": start}
+		if len(values) > 0 {
+			object["avg"] = math.Round(sum / float64(len(values)))
+		}
+		return object
+	}
+	metric := func(metricType string, object map[string]any) map[string]any {
+		return map[string]any{"type": metricType, "object": object}
+	}
+	simple := func(metricType string, base, noise float64) map[string]any {
+		value := math.Round(base + noise*mockRand(day, metricType).NormFloat64())
+		return metric(metricType, map[string]any{"value": value, "day_start_timestamp": start})
+	}
+
+	steps := series("steps", "Steps", "", 900, 150, 150, 60)
+	var total float64
+	for _, reading := range steps["values"].([]map[string]any) {
+		v := math.Max(0, math.Round(reading["value"].(float64)))
+		reading["value"] = v
+		total += v
+	}
+	steps["total"] = total
+
+	metrics := []map[string]any{
+		metric("hr", series("hr", "Heart Rate", "BPM", 300, 68, 12, 4)),
+		metric("hrv", series("hrv", "HRV", "ms", 1800, 50, -10, 6)),
+		metric("temp", series("temp", "Skin Temperature", "°C", 900, 36.2, 0.4, 0.1)),
+		metric("spo2", series("spo2", "SpO2", "%", 3600, 97, 0, 0.8)),
+		metric("glucose", series("glucose", "Glucose", "mg/dL", 900, 100, 15, 8)),
+		metric("steps", steps),
+		simple("sleep_score", 80, 6),
+		simple("total_sleep", 440, 30),
+		simple("deep_sleep", 90, 15),
+		simple("rem_sleep", 100, 15),
+		simple("light_sleep", 250, 20),
+		simple("recovery_index", 75, 8),
+		simple("movement_index", 60, 10),
+		simple("vo2_max", 42, 1),
+		simple("average_glucose", 102, 5),
+		simple("time_in_target", 90, 4),
+	}
+	rng := mockRand(day, "sleep")
+	score := rng.IntN(20) + 70
+	metrics = append(metrics, metric("sleep", map[string]any{
+		"day_start_timestamp": start,
+		"score":               score,
+		"total_sleep":         420 + rng.IntN(60),
+		"efficiency":          85 + rng.IntN(10),
+	}))
+	return metrics
+}
+
+// mockRand is seeded by the date and metric, so a metric's readings don't
+// shift when another metric gains readings
+func mockRand(day time.Time, metricType string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(day.Format("2006-01-02") + "/" + metricType))
+	return rand.New(rand.NewPCG(h.Sum64(), 0))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// stubProxy answers every proxied request itself and records the hosts asked for
+type stubProxy struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (p *stubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.hosts = append(p.hosts, r.URL.Host)
+	p.mu.Unlock()
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Write([]byte(`{"status":200,"data":{"metrics":{"2024-01-01":[]}}}`))
+}
+
+func TestProxyURLRoutesAPIAndRemoteWrite(t *testing.T) {
+	stub := &stubProxy{}
+	proxyServer := httptest.NewServer(stub)
+	defer proxyServer.Close()
+
+	proxy, err := proxyFunc(proxyServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newAPIClient(5*time.Second, proxy, "test")
+	if _, err := makeRequest(context.Background(), client, "http://api.example.invalid/daily_metrics", dateParams("2024-01-01"), "token"); err != nil {
+		t.Fatalf("API request through the proxy: %v", err)
+	}
+
+	cfg := defaultConfig()
+	cfg.ProxyURL = proxyServer.URL
+	cfg.RemoteWriteURL = "http://write.example.invalid/api/v1/write"
+	exporter, err := newBackendExporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Write([]prompb.TimeSeries{buildTimeSeries("ultrahuman_heart_rate_bpm", 60, 1704067300000, nil)}); err != nil {
+		t.Fatalf("remote write through the proxy: %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.hosts) != 2 || stub.hosts[0] != "api.example.invalid" || stub.hosts[1] != "write.example.invalid" {
+		t.Errorf("proxy saw hosts %v, want the API then the remote write endpoint", stub.hosts)
+	}
+}
+
+func TestProxyURLInvalid(t *testing.T) {
+	for _, value := range []string{"proxy:3128", "://bad", "http://"} {
+		if _, err := proxyFunc(value); err == nil {
+			t.Errorf("proxyFunc(%q) accepted an invalid URL", value)
+		}
+	}
+}
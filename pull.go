@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// cachedResponse is the last successfully parsed response for an account
+type cachedResponse struct {
+	resp      *APIResponse
+	fetchedAt time.Time
+	stale     bool // the most recent fetch failed, resp is from an earlier cycle
+}
+
+// responseCache keeps the last good response per account label so /metrics
+// keeps serving values when a fetch fails
+var (
+	responseCache   = make(map[string]*cachedResponse)
+	responseCacheMu sync.Mutex
+)
+
+func cacheResponse(account string, resp *APIResponse) {
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+	responseCache[account] = &cachedResponse{resp: resp, fetchedAt: time.Now()}
+}
+
+// markStale flags an account's cached data as stale after a failed fetch
+func markStale(account string) {
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+	if cached, ok := responseCache[account]; ok {
+		cached.stale = true
+	}
+}
+
+// pullSample is one gauge line on the /metrics endpoint
+type pullSample struct {
+	suffix string // _bucket, _sum or _count for histogram lines
+	labels map[string]string
+	value  float64
+}
+
+// pullFamily groups the samples sharing a metric name
+type pullFamily struct {
+	help        string
+	isCounter   bool
+	isHistogram bool
+	samples     []pullSample
+}
+
+// latestValue extracts the current value of a metric: the newest reading for
+// timeseries metrics (the running total for steps) or the value of a simple metric
+func latestValue(m Metric, config MetricConfig) (float64, bool) {
+	switch config.MetricType {
+	case "timeseries":
+		var v TimeSeriesMetric
+		if err := json.Unmarshal(m.Object, &v); err != nil {
+			return 0, false
+		}
+		if m.Type == "steps" {
+			return v.Total, v.present()
+		}
+		if len(v.Values) == 0 {
+			return v.LastReading, v.present()
+		}
+		latest := v.Values[0]
+		for _, reading := range v.Values[1:] {
+			if reading.Timestamp > latest.Timestamp {
+				latest = reading
+			}
+		}
+		return latest.Value, true
+	case "simple":
+		var v SimpleMetric
+		if err := json.Unmarshal(m.Object, &v); err != nil || v.Value == nil {
+			return 0, false
+		}
+		return *v.Value, true
+	}
+	return 0, false
+}
+
+// pullFamilies maps metric names to their families
+type pullFamilies map[string]*pullFamily
+
+func (f pullFamilies) add(name, help string, isCounter bool, labels map[string]string, value float64) {
+	name = exportedName(name)
+	family, ok := f[name]
+	if !ok {
+		family = &pullFamily{help: help, isCounter: isCounter}
+		f[name] = family
+	}
+	family.samples = append(family.samples, pullSample{labels: labels, value: value})
+}
+
+// addHistogram adds a histogram from its upper bounds and cumulative bucket counts
+func (f pullFamilies) addHistogram(name, help string, bounds []float64, buckets []uint64, sum float64, count uint64) {
+	family := &pullFamily{help: help, isHistogram: true}
+	for i, bound := range bounds {
+		family.samples = append(family.samples, pullSample{suffix: "_bucket", labels: map[string]string{"le": fmt.Sprint(bound)}, value: float64(buckets[i])})
+	}
+	family.samples = append(family.samples,
+		pullSample{suffix: "_bucket", labels: map[string]string{"le": "+Inf"}, value: float64(count)},
+		pullSample{suffix: "_sum", value: sum},
+		pullSample{suffix: "_count", value: float64(count)},
+	)
+	f[exportedName(name)] = family
+}
+
+// collectPullFamilies builds the ring data gauges from the cached responses,
+// using the newest date of each account's response
+func collectPullFamilies(cfg *Config) pullFamilies {
+	families := make(pullFamilies)
+
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+
+	for _, account := range cfg.accounts() {
+		cached, ok := responseCache[account.Label]
+		if !ok {
+			continue
+		}
+		labels := account.seriesLabels(cfg.Labels)
+
+		var latestDate string
+		for date := range cached.resp.Data.Metrics {
+			if date > latestDate {
+				latestDate = date
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, m := range cached.resp.Data.Metrics[latestDate] {
+			config, ok := metricRegistry[m.Type]
+			if !ok || config.PrometheusName == "" || !cfg.metricEnabled(m.Type) || seen[config.PrometheusName] {
+				continue
+			}
+			value, ok := latestValue(m, config)
+			if !ok {
+				continue
+			}
+			seen[config.PrometheusName] = true
+			families.add(config.PrometheusName, config.DisplayName, config.IsCounter, labels, value)
+
+			if config.MetricType == "timeseries" {
+				var v TimeSeriesMetric
+				if err := json.Unmarshal(m.Object, &v); err != nil {
+					continue
+				}
+				if len(v.Values) > 1 {
+					families.add("ultrahuman_reading_gap_seconds", "Largest gap between consecutive readings today", false, withLabel(labels, "metric", m.Type), float64(largestGap(v.Values)))
+				}
+				families.add("ultrahuman_reading_count", "Readings captured today", false, withLabel(labels, "metric", m.Type), float64(len(v.Values)))
+				// The API's own day summaries, so dashboards can pick one
+				// (steps already exposes its total as the main series)
+				if m.Type != "steps" && v.present() {
+					families.add(config.PrometheusName+"_avg", config.DisplayName+" (day average)", false, labels, v.Avg)
+					families.add(config.PrometheusName+"_last", config.DisplayName+" (last reading)", false, labels, v.LastReading)
+				}
+			}
+		}
+	}
+	return families
+}
+
+// writePrometheusText renders families in the Prometheus text exposition format
+func writePrometheusText(w io.Writer, families map[string]*pullFamily) {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := families[name]
+		metricType := "gauge"
+		if family.isCounter {
+			metricType = "counter"
+		} else if family.isHistogram {
+			metricType = "histogram"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+		for _, sample := range family.samples {
+			fmt.Fprintf(w, "%s%s%s %g\n", name, sample.suffix, formatLabelSet(sample.labels), sample.value)
+		}
+	}
+}
+
+// formatLabelSet renders {a="1",b="2"} with names sorted, or "" when empty
+func formatLabelSet(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// handlePullMetrics serves the latest cached ring data for Prometheus scrapes,
+// plus the exporter's own telemetry unless that has a listener of its own
+func handlePullMetrics(cfg *Config, withTelemetry bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheusText(w, pullMetricFamilies(cfg, withTelemetry))
+	}
+}
+
+// pullMetricFamilies is the ring data of /metrics, optionally with telemetry
+func pullMetricFamilies(cfg *Config, withTelemetry bool) pullFamilies {
+	families := collectPullFamilies(cfg)
+	if withTelemetry {
+		for name, family := range collectTelemetryFamilies(cfg) {
+			families[name] = family
+		}
+	}
+	return families
+}
+
+// maxReplayPerSeries bounds the readings buffered for /metrics/readings per series
+const maxReplayPerSeries = 1000
+
+// replayQueue holds the not yet scraped readings of one series, oldest first
+type replayQueue struct {
+	name    string
+	labels  map[string]string
+	samples []prompb.Sample
+}
+
+// replayBuffer feeds individual readings to pull-based scrapers. A Prometheus
+// scrape can only carry one sample per series, so each scrape of
+// /metrics/readings hands out the oldest unscraped reading of every series
+// with its original timestamp. As long as scrapes are more frequent than
+// readings arrive, the full intraday curve ends up in the TSDB.
+type replayBuffer struct {
+	mu     sync.Mutex
+	queues map[string]*replayQueue
+}
+
+var pendingReadings = &replayBuffer{queues: make(map[string]*replayQueue)}
+
+func (b *replayBuffer) add(timeseries []prompb.TimeSeries) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ts := range timeseries {
+		key := labelsKey(ts.Labels)
+		queue, ok := b.queues[key]
+		if !ok {
+			queue = &replayQueue{name: seriesName(ts), labels: make(map[string]string)}
+			for _, l := range ts.Labels {
+				if l.Name != "__name__" {
+					queue.labels[l.Name] = l.Value
+				}
+			}
+			b.queues[key] = queue
+		}
+		queue.samples = append(queue.samples, ts.Samples...)
+		if over := len(queue.samples) - maxReplayPerSeries; over > 0 {
+			queue.samples = queue.samples[over:]
+		}
+	}
+}
+
+// next pops the oldest pending sample of every series
+func (b *replayBuffer) next() []replayQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var popped []replayQueue
+	for _, queue := range b.queues {
+		if len(queue.samples) == 0 {
+			continue
+		}
+		popped = append(popped, replayQueue{name: queue.name, labels: queue.labels, samples: queue.samples[:1]})
+		queue.samples = queue.samples[1:]
+	}
+	return popped
+}
+
+// handleReadings serves one buffered reading per series with its timestamp
+func handleReadings(w http.ResponseWriter, r *http.Request) {
+	popped := pendingReadings.next()
+	sort.Slice(popped, func(i, j int) bool {
+		if popped[i].name != popped[j].name {
+			return popped[i].name < popped[j].name
+		}
+		return formatLabelSet(popped[i].labels) < formatLabelSet(popped[j].labels)
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	typed := make(map[string]bool)
+	for _, queue := range popped {
+		if !typed[queue.name] {
+			fmt.Fprintf(w, "# TYPE %s gauge\n", queue.name)
+			typed[queue.name] = true
+		}
+		sample := queue.samples[0]
+		fmt.Fprintf(w, "%s%s %g %d\n", queue.name, formatLabelSet(queue.labels), sample.Value, sample.Timestamp)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// PushgatewayClient pushes the latest value of each series to a Prometheus
+// Pushgateway. The Pushgateway does not accept sample timestamps, so only the
+// most recent sample per series is sent and it is stamped at scrape time.
+type PushgatewayClient struct {
+	url      string
+	job      string
+	instance string
+	grouping map[string]string // static labels used as grouping key
+	client   *http.Client
+}
+
+func NewPushgatewayClient(baseURL, job, instance string, grouping map[string]string) *PushgatewayClient {
+	return &PushgatewayClient{
+		url:      strings.TrimRight(baseURL, "/"),
+		job:      job,
+		instance: instance,
+		grouping: grouping,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *PushgatewayClient) Write(timeseries []prompb.TimeSeries) error {
+	body := c.render(timeseries)
+	httpReq, err := http.NewRequest("POST", c.groupURL(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("pushgateway push failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// groupURL builds /metrics/job/<job>/instance/<instance>/<label>/<value>...
+func (c *PushgatewayClient) groupURL() string {
+	var b strings.Builder
+	b.WriteString(c.url)
+	b.WriteString("/metrics")
+	writeGroupingPair(&b, "job", c.job)
+	if c.instance != "" {
+		writeGroupingPair(&b, "instance", c.instance)
+	}
+
+	names := make([]string, 0, len(c.grouping))
+	for name := range c.grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeGroupingPair(&b, name, c.grouping[name])
+	}
+	return b.String()
+}
+
+// writeGroupingPair appends a grouping label, using the base64 form for
+// values the URL path can't carry
+func writeGroupingPair(b *strings.Builder, name, value string) {
+	if value == "" || strings.Contains(value, "/") {
+		fmt.Fprintf(b, "/%s@base64/%s", name, base64.RawURLEncoding.EncodeToString([]byte(value)))
+		if value == "" {
+			b.WriteString("=")
+		}
+		return
+	}
+	fmt.Fprintf(b, "/%s/%s", name, url.PathEscape(value))
+}
+
+// render formats the latest sample of each series in the Prometheus text format
+func (c *PushgatewayClient) render(timeseries []prompb.TimeSeries) []byte {
+	type latest struct {
+		name   string
+		labels string
+		sample prompb.Sample
+	}
+	series := make(map[string]*latest)
+	for _, ts := range timeseries {
+		name := seriesName(ts)
+		labels := c.formatLabels(ts.Labels)
+		key := name + labels
+		for _, sample := range ts.Samples {
+			if cur, ok := series[key]; !ok || sample.Timestamp > cur.sample.Timestamp {
+				series[key] = &latest{name: name, labels: labels, sample: sample}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	typed := make(map[string]bool)
+	for _, key := range keys {
+		s := series[key]
+		if !typed[s.name] {
+			fmt.Fprintf(&b, "# TYPE %s gauge\n", s.name)
+			typed[s.name] = true
+		}
+		fmt.Fprintf(&b, "%s%s %g\n", s.name, s.labels, s.sample.Value)
+	}
+	return b.Bytes()
+}
+
+// formatLabels renders the labels that aren't already part of the grouping key
+func (c *PushgatewayClient) formatLabels(labels []prompb.Label) string {
+	var parts []string
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			continue
+		}
+		if _, grouped := c.grouping[l.Name]; grouped {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%q", l.Name, l.Value))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxAPIBurst caps how many requests the limiter lets through back to back
+const maxAPIBurst = 5
+
+// rateLimiter is a token bucket shared by every API request, so several
+// accounts, backfill workers and retries together stay under --api-rate-limit
+type rateLimiter struct {
+	mu     sync.Mutex
+	every  time.Duration // one token is added per interval
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// apiLimiter is nil unless --api-rate-limit is set
+var apiLimiter *rateLimiter
+
+// newRateLimiter allows perMinute requests a minute, or returns nil for no limit
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	burst := float64(min(perMinute, maxAPIBurst))
+	return &rateLimiter{
+		every:  time.Minute / time.Duration(perMinute),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a request may be sent or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = min(l.burst, l.tokens+float64(now.Sub(l.last))/float64(l.every))
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) * float64(l.every))
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// recordResponse archives a raw API response body as dir/<date>-<unixtime>.json
+// (or .json.gz) and prunes recordings older than the retention. Failures are
+// logged, never returned, so recording can't abort a fetch.
+func recordResponse(cfg *Config, date string, body []byte) {
+	if cfg.RecordDir == "" || body == nil {
+		return
+	}
+	if err := writeRecording(cfg.RecordDir, date, body, cfg.RecordGzip); err != nil {
+		log.Printf("Recording response: %v", err)
+	}
+	if cfg.RecordRetention > 0 {
+		if err := pruneRecordings(cfg.RecordDir, time.Duration(cfg.RecordRetention)*time.Second); err != nil {
+			log.Printf("Pruning recordings: %v", err)
+		}
+	}
+}
+
+func writeRecording(dir, date string, body []byte, compress bool) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.json", date, time.Now().Unix())
+	if compress {
+		name += ".gz"
+	}
+
+	// Write to a temp file and rename so a crash never leaves a partial recording
+	tmp, err := os.CreateTemp(dir, ".recording-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if compress {
+		gz := gzip.NewWriter(tmp)
+		if _, err := gz.Write(body); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			tmp.Close()
+			return err
+		}
+	} else if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// pruneRecordings removes recordings last modified before now - retention
+func pruneRecordings(dir string, retention time.Duration) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-retention)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
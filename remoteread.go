@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesRetention is how long pushed samples stay queryable via /read
+const seriesRetention = 24 * time.Hour
+
+// seriesStore keeps recently pushed samples in memory, keyed by label set,
+// so the exporter can answer Prometheus remote read queries itself
+type seriesStore struct {
+	mu     sync.Mutex
+	series map[string]*prompb.TimeSeries
+}
+
+var recentSeries = &seriesStore{series: make(map[string]*prompb.TimeSeries)}
+
+// add records the samples of each series and drops samples past the retention
+func (s *seriesStore) add(timeseries []prompb.TimeSeries) {
+	cutoff := time.Now().Add(-seriesRetention).UnixMilli()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ts := range timeseries {
+		key := labelsKey(ts.Labels)
+		stored, ok := s.series[key]
+		if !ok {
+			stored = &prompb.TimeSeries{Labels: append([]prompb.Label(nil), ts.Labels...)}
+			s.series[key] = stored
+		}
+		stored.Samples = append(stored.Samples, ts.Samples...)
+	}
+
+	for key, stored := range s.series {
+		sort.Slice(stored.Samples, func(i, j int) bool { return stored.Samples[i].Timestamp < stored.Samples[j].Timestamp })
+		kept := stored.Samples[:0]
+		for _, sample := range stored.Samples {
+			if sample.Timestamp >= cutoff {
+				kept = append(kept, sample)
+			}
+		}
+		stored.Samples = kept
+		if len(kept) == 0 {
+			delete(s.series, key)
+		}
+	}
+}
+
+// query returns copies of the series matching every matcher, limited to samples in [start, end]
+func (s *seriesStore) query(q *prompb.Query) ([]*prompb.TimeSeries, error) {
+	matchers := make([]func(prompb.TimeSeries) bool, 0, len(q.Matchers))
+	for _, m := range q.Matchers {
+		matcher, err := newLabelMatcher(m)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.series))
+	for key := range s.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result []*prompb.TimeSeries
+	for _, key := range keys {
+		stored := s.series[key]
+		matched := true
+		for _, match := range matchers {
+			if !match(*stored) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		ts := &prompb.TimeSeries{Labels: append([]prompb.Label(nil), stored.Labels...)}
+		for _, sample := range stored.Samples {
+			if sample.Timestamp >= q.StartTimestampMs && sample.Timestamp <= q.EndTimestampMs {
+				ts.Samples = append(ts.Samples, sample)
+			}
+		}
+		if len(ts.Samples) > 0 {
+			result = append(result, ts)
+		}
+	}
+	return result, nil
+}
+
+// newLabelMatcher compiles a remote read matcher. A missing label matches as
+// the empty string, as in PromQL.
+func newLabelMatcher(m *prompb.LabelMatcher) (func(prompb.TimeSeries) bool, error) {
+	value := func(ts prompb.TimeSeries) string {
+		for _, l := range ts.Labels {
+			if l.Name == m.Name {
+				return l.Value
+			}
+		}
+		return ""
+	}
+
+	switch m.Type {
+	case prompb.LabelMatcher_EQ:
+		return func(ts prompb.TimeSeries) bool { return value(ts) == m.Value }, nil
+	case prompb.LabelMatcher_NEQ:
+		return func(ts prompb.TimeSeries) bool { return value(ts) != m.Value }, nil
+	case prompb.LabelMatcher_RE, prompb.LabelMatcher_NRE:
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex matcher %q: %w", m.Value, err)
+		}
+		negate := m.Type == prompb.LabelMatcher_NRE
+		return func(ts prompb.TimeSeries) bool { return re.MatchString(value(ts)) != negate }, nil
+	default:
+		return nil, fmt.Errorf("unsupported matcher type %v", m.Type)
+	}
+}
+
+func labelsKey(labels []prompb.Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(l.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// handleRemoteRead serves the Prometheus remote read protocol (SAMPLES response type)
+func handleRemoteRead(w http.ResponseWriter, r *http.Request) {
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decompressing request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var req prompb.ReadRequest
+	if err := req.Unmarshal(data); err != nil {
+		http.Error(w, fmt.Sprintf("unmarshaling request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp := &prompb.ReadResponse{Results: make([]*prompb.QueryResult, len(req.Queries))}
+	for i, q := range req.Queries {
+		timeseries, err := recentSeries.query(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Results[i] = &prompb.QueryResult{Timeseries: timeseries}
+	}
+
+	out, err := resp.Marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	w.Write(snappy.Encode(nil, out))
+}
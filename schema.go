@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// strictAPI turns response shape problems into fetch errors (--strict);
+// otherwise they are only logged
+var strictAPI bool
+
+// passthroughTypes accepts metric types missing from the registry, which are
+// pushed as ultrahuman_raw_<type> (--passthrough-unknown)
+var passthroughTypes bool
+
+// Shape problems are logged once each, not on every fetch cycle
+var (
+	loggedShapeProblems   = make(map[string]bool)
+	loggedShapeProblemsMu sync.Mutex
+)
+
+// checkResponseShape lists the ways a decoded response differs from what the
+// extractors expect: missing fields, metric types missing from the registry
+// and objects that don't decode. Each of these would otherwise surface only as
+// a silently missing value.
+func checkResponseShape(resp *APIResponse) []string {
+	var envelope struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(resp.raw, &envelope); err != nil || envelope.Data == nil {
+		return []string{"missing field data"}
+	}
+	if _, ok := envelope.Data["metrics"]; !ok {
+		return []string{"missing field data.metrics"}
+	}
+
+	var problems []string
+	for _, date := range resp.Data.sortedDates() {
+		for _, m := range resp.Data.Metrics[date] {
+			if problem := checkMetricShape(m); problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems
+}
+
+// checkMetricShape describes what is wrong with one metric, or returns ""
+func checkMetricShape(m Metric) string {
+	if m.Type == "" {
+		return "metric without a type"
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(m.Object, &fields); err != nil || fields == nil {
+		return fmt.Sprintf("%s: object is missing or not a JSON object", m.Type)
+	}
+
+	kind := "sleep"
+	if m.Type != "sleep" {
+		config, ok := metricRegistry[m.Type]
+		if !ok && passthroughTypes {
+			return ""
+		}
+		if !ok {
+			return fmt.Sprintf("%s: metric type not in the registry", m.Type)
+		}
+		kind = config.MetricType
+	}
+
+	switch kind {
+	case "timeseries":
+		if _, ok := fields["values"]; !ok {
+			return fmt.Sprintf("%s: missing field values", m.Type)
+		}
+		var v struct {
+			Values []map[string]json.RawMessage `json:"values"`
+		}
+		if err := json.Unmarshal(m.Object, &v); err != nil {
+			return fmt.Sprintf("%s: %v", m.Type, err)
+		}
+		for _, reading := range v.Values {
+			for _, key := range []string{"value", "timestamp"} {
+				if _, ok := reading[key]; !ok {
+					return fmt.Sprintf("%s: reading missing field %s", m.Type, key)
+				}
+			}
+		}
+		var ts TimeSeriesMetric
+		if err := json.Unmarshal(m.Object, &ts); err != nil {
+			return fmt.Sprintf("%s: %v", m.Type, err)
+		}
+	case "simple":
+		// A null value is an ordinary gap; an absent one is a different shape
+		if _, ok := fields["value"]; !ok {
+			return fmt.Sprintf("%s: missing field value", m.Type)
+		}
+		var v SimpleMetric
+		if err := json.Unmarshal(m.Object, &v); err != nil {
+			return fmt.Sprintf("%s: %v", m.Type, err)
+		}
+	case "sleep":
+		var v SleepMetric
+		if err := json.Unmarshal(m.Object, &v); err != nil {
+			return fmt.Sprintf("%s: %v", m.Type, err)
+		}
+	}
+	return ""
+}
+
+// reportShapeProblems logs problems not logged before, or with --strict
+// returns them as an error
+func reportShapeProblems(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	if strictAPI {
+		return fmt.Errorf("unexpected response shape: %s", strings.Join(problems, "; "))
+	}
+	loggedShapeProblemsMu.Lock()
+	defer loggedShapeProblemsMu.Unlock()
+	for _, problem := range problems {
+		if !loggedShapeProblems[problem] {
+			loggedShapeProblems[problem] = true
+			log.Printf("Unexpected API response shape: %s", problem)
+		}
+	}
+	return nil
+}
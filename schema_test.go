@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStrictShapeWithPassthroughUnknown(t *testing.T) {
+	defer func(strict, passthrough bool) { strictAPI, passthroughTypes = strict, passthrough }(strictAPI, passthroughTypes)
+	strictAPI = true
+
+	body := []byte(`{"status":200,"data":{"metrics":{"2024-01-01":[
+		{"type":"hr","object":{"title":"Heart Rate","values":[{"value":60,"timestamp":1704067300}]}},
+		{"type":"new_score","object":{"value":7,"day_start_timestamp":1704067200}}]}}}`)
+	var resp APIResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	resp.raw = body
+
+	passthroughTypes = false
+	if err := reportShapeProblems(checkResponseShape(&resp)); err == nil {
+		t.Error("--strict accepted an unregistered metric type")
+	}
+	passthroughTypes = true
+	if err := reportShapeProblems(checkResponseShape(&resp)); err != nil {
+		t.Errorf("--strict --passthrough-unknown: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// newServer builds the main serve-mode HTTP server on --port with its own
+// mux, so several can run in one process (e.g. tests listening on :0)
+func newServer(cfg *Config, fetchers []*Fetcher) *http.Server {
+	unhealthyAfter := time.Duration(cfg.UnhealthyAfter) * time.Second
+	if unhealthyAfter <= 0 {
+		unhealthyAfter = 3 * time.Duration(cfg.Interval) * time.Second
+	}
+
+	mux := http.NewServeMux()
+
+	// Simple health endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "ok\n")
+	})
+	// Readiness fails once no fetch has succeeded within unhealthyAfter
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		fetchStatusMu.Lock()
+		last := lastSuccessfulFetch
+		fetchStatusMu.Unlock()
+
+		if last.IsZero() || time.Since(last) > unhealthyAfter {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "no successful fetch within %s\n", unhealthyAfter)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "ok\n")
+	})
+	if cfg.MetricsListen == "" {
+		mux.HandleFunc("/metrics", handlePullMetrics(cfg, cfg.TelemetryListen == ""))
+		mux.HandleFunc("/metrics/readings", handleReadings)
+	}
+	mux.HandleFunc("/events", handleEvents)
+	if cfg.RemoteRead {
+		mux.HandleFunc("/read", handleRemoteRead)
+	}
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := statusResponse{
+			Status:          "running",
+			Version:         version,
+			IntervalSeconds: int(cfg.Interval),
+		}
+
+		for _, fetcher := range fetchers {
+			latest := fetcher.LatestTimestamp()
+			status.LastDataTimestamp = max(status.LastDataTimestamp, latest)
+			if len(cfg.Accounts) > 0 {
+				if status.Accounts == nil {
+					status.Accounts = make(map[string]int64, len(fetchers))
+				}
+				status.Accounts[fetcher.account.Label] = latest
+			}
+		}
+
+		fetchStatusMu.Lock()
+		status.InitialFetchComplete = initialFetchDone
+		if !lastSuccessfulFetch.IsZero() {
+			status.LastSuccessTimestamp = lastSuccessfulFetch.Unix()
+		}
+		status.LastError = lastFetchError
+		status.FetchCount = fetchCount
+		status.ConsecutiveFailures = consecutiveFailures
+		fetchStatusMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	return &http.Server{Addr: net.JoinHostPort(cfg.ListenAddress, strconv.Itoa(cfg.Port)), Handler: withWebAuth(cfg, mux)}
+}
+
+// newListenerServers builds the servers for --metrics-listen and
+// --telemetry-listen. Ring data stays on the main port unless
+// --metrics-listen moves it; the exporter's telemetry follows it unless
+// --telemetry-listen is set.
+func newListenerServers(cfg *Config) []*http.Server {
+	var servers []*http.Server
+	if cfg.MetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", handlePullMetrics(cfg, cfg.TelemetryListen == ""))
+		mux.HandleFunc("/metrics/readings", handleReadings)
+		servers = append(servers, &http.Server{Addr: cfg.MetricsListen, Handler: withWebAuth(cfg, mux)})
+	}
+	if cfg.TelemetryListen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", handleTelemetry(cfg))
+		servers = append(servers, &http.Server{Addr: cfg.TelemetryListen, Handler: withWebAuth(cfg, mux)})
+	}
+	return servers
+}
+
+// withWebAuth requires the --web-username/--web-password basic auth
+// credentials on every path except /health, which stays open for probes
+func withWebAuth(cfg *Config, next http.Handler) http.Handler {
+	if cfg.WebUsername == "" && cfg.WebPassword == "" {
+		return next
+	}
+	// Comparing hashes keeps the check constant-time regardless of length
+	wantUser := sha256.Sum256([]byte(cfg.WebUsername))
+	wantPass := sha256.Sum256([]byte(cfg.WebPassword))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		gotUser := sha256.Sum256([]byte(user))
+		gotPass := sha256.Sum256([]byte(pass))
+		userOK := subtle.ConstantTimeCompare(gotUser[:], wantUser[:])
+		passOK := subtle.ConstantTimeCompare(gotPass[:], wantPass[:])
+		if !ok || userOK&passOK != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="uh-ring"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveExtra runs an additional listener; failing to bind is fatal like the main port
+func serveExtra(srv *http.Server) {
+	if srv.TLSConfig != nil {
+		log.Printf("Serving https://%s", srv.Addr)
+		log.Fatal(srv.ListenAndServeTLS("", ""))
+	}
+	log.Printf("Serving %s", srv.Addr)
+	log.Fatal(srv.ListenAndServe())
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// startServer serves newServer on a free port and returns its base URL
+func startServer(t *testing.T, cfg *Config, fetchers []*Fetcher) string {
+	t.Helper()
+	cfg.ListenAddress = "127.0.0.1"
+	cfg.Port = 0
+	server := newServer(cfg, fetchers)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + listener.Addr().String()
+}
+
+func TestNewServerOnFreePort(t *testing.T) {
+	cfg := defaultConfig()
+	fetcher := newFetcher(cfg, nil, "", Account{})
+	fetcher.advanceLatest(1704067300)
+
+	// Two servers in one process: each has its own mux
+	first := startServer(t, cfg, []*Fetcher{fetcher})
+	second := startServer(t, defaultConfig(), nil)
+
+	for _, base := range []string{first, second} {
+		resp, err := http.Get(base + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "ok\n" {
+			t.Errorf("%s/health = %d %q", base, resp.StatusCode, body)
+		}
+	}
+
+	resp, err := http.Get(first + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status statusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "running" || status.LastDataTimestamp != 1704067300 || status.IntervalSeconds != int(cfg.Interval) {
+		t.Errorf("/status = %+v", status)
+	}
+}
+
+func TestNewServerWebAuth(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.WebUsername, cfg.WebPassword = "admin", "secret"
+	base := startServer(t, cfg, nil)
+
+	get := func(path string, auth bool) int {
+		req, _ := http.NewRequest("GET", base+path, nil)
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get("/health", false); code != http.StatusOK {
+		t.Errorf("/health without credentials = %d, want 200", code)
+	}
+	if code := get("/status", false); code != http.StatusUnauthorized {
+		t.Errorf("/status without credentials = %d, want 401", code)
+	}
+	if code := get("/status", true); code != http.StatusOK {
+		t.Errorf("/status with credentials = %d, want 200", code)
+	}
+}
+
+func TestStatusWhilePushing(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Quiet = true
+	fetcher := newFetcher(cfg, nil, "", Account{})
+	handler := newServer(cfg, []*Fetcher{fetcher}).Handler
+
+	const pushes = 200
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range pushes {
+			ts := int64(1704067200 + 60*i)
+			metrics := []Metric{timeseriesMetric(t, "hr", map[string]any{"title": "Heart Rate", "values": readings(60, ts)})}
+			if err := fetcher.pushMetrics(metrics, noopExporter{}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		var last int64
+		for range pushes {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+			var status statusResponse
+			if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+				t.Error(err)
+				return
+			}
+			if status.LastDataTimestamp < last {
+				t.Errorf("last_data_timestamp went back from %d to %d", last, status.LastDataTimestamp)
+			}
+			last = status.LastDataTimestamp
+		}
+	}()
+	wg.Wait()
+
+	if want := int64(1704067200 + 60*(pushes-1)); fetcher.LatestTimestamp() != want {
+		t.Errorf("LatestTimestamp = %d, want %d", fetcher.LatestTimestamp(), want)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const spoolFileExt = ".pb.snappy"
+
+// spoolExporter buffers batches that failed to send in a spool directory and
+// replays them, oldest first, before any new data on later writes. The spool
+// is capped at maxBytes; the oldest files are dropped when it overflows.
+type spoolExporter struct {
+	next     Exporter
+	dir      string
+	maxBytes int64
+}
+
+func newSpoolExporter(next Exporter, dir string, maxBytes int64) (*spoolExporter, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating spool dir: %w", err)
+	}
+	return &spoolExporter{next: next, dir: dir, maxBytes: maxBytes}, nil
+}
+
+// Write returns nil once a batch the receiver couldn't take is safely on
+// disk, so the caller marks its readings pushed instead of collecting and
+// spooling them again next cycle. Only a batch that couldn't be spooled, or
+// one the receiver rejected outright, is an error.
+func (s *spoolExporter) Write(timeseries []prompb.TimeSeries) error {
+	if err := s.replay(); err != nil {
+		// The receiver is still failing; keep the new batch behind the spooled ones
+		if spoolErr := s.spool(timeseries); spoolErr != nil {
+			return fmt.Errorf("replaying spool: %w (spooling batch: %v)", err, spoolErr)
+		}
+		log.Printf("Receiver still failing (%v); spooled %d series for replay", err, len(timeseries))
+		return nil
+	}
+
+	err := s.next.Write(timeseries)
+	if err != nil && isRetriable(err) {
+		// Batches the receiver already accepted aren't spooled, so replay
+		// doesn't send them twice
+		unsent := unsentSeries(timeseries, err)
+		if spoolErr := s.spool(unsent); spoolErr != nil {
+			return fmt.Errorf("%w (spooling batch: %v)", err, spoolErr)
+		}
+		log.Printf("Write failed (%v); spooled %d series for replay", err, len(unsent))
+		return nil
+	}
+	return err
+}
+
+// unsentSeries returns the series of a failed write that didn't reach the
+// receiver: all of them unless the exporter reports a partial write
+func unsentSeries(timeseries []prompb.TimeSeries, err error) []prompb.TimeSeries {
+	var partial *partialWriteError
+	if errors.As(err, &partial) {
+		return partial.unsent
+	}
+	return timeseries
+}
+
+// replay sends spooled batches oldest first, removing each once it is
+// accepted. A file partly accepted is rewritten with the series still pending.
+func (s *spoolExporter) replay() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		timeseries, err := readSpoolFile(file)
+		if err != nil {
+			log.Printf("Dropping unreadable spool file %s: %v", file, err)
+			os.Remove(file)
+			continue
+		}
+		err = s.next.Write(timeseries)
+		if err == nil {
+			log.Printf("Replayed %d spooled series from %s", len(timeseries), filepath.Base(file))
+			os.Remove(file)
+			continue
+		}
+
+		// A rejected batch is dropped; whatever the receiver neither accepted
+		// nor rejected stays spooled
+		var pending []prompb.TimeSeries
+		var partial *partialWriteError
+		switch {
+		case errors.As(err, &partial):
+			pending = partial.unsent
+		case isRetriable(err):
+			pending = timeseries
+		}
+		if !isRetriable(err) {
+			log.Printf("Dropping series in spool file %s rejected by receiver: %v", filepath.Base(file), err)
+		}
+		switch {
+		case len(pending) == 0:
+			os.Remove(file)
+		case len(pending) < len(timeseries):
+			if rewriteErr := writeSpoolFile(file, pending); rewriteErr != nil {
+				log.Printf("Spool: rewriting %s: %v", filepath.Base(file), rewriteErr)
+			} else {
+				log.Printf("Replayed %d of %d spooled series from %s", len(timeseries)-len(pending), len(timeseries), filepath.Base(file))
+			}
+		}
+		if isRetriable(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// spool writes a batch to the spool directory and enforces the size cap
+func (s *spoolExporter) spool(timeseries []prompb.TimeSeries) error {
+	if len(timeseries) == 0 {
+		return nil
+	}
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), spoolFileExt))
+	if err := writeSpoolFile(name, timeseries); err != nil {
+		return err
+	}
+	s.prune()
+	return nil
+}
+
+// writeSpoolFile writes timeseries to path through a temp file, so a crash
+// never leaves a partial batch behind
+func writeSpoolFile(path string, timeseries []prompb.TimeSeries) error {
+	req := &prompb.WriteRequest{Timeseries: timeseries}
+	data, err := req.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshaling batch: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, snappy.Encode(nil, data), 0o600); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("renaming %s: %w", tmp, err)
+	}
+	return nil
+}
+
+// prune removes the oldest spool files until the total size fits maxBytes
+func (s *spoolExporter) prune() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	files, err := s.files()
+	if err != nil {
+		log.Printf("Spool: listing files: %v", err)
+		return
+	}
+
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; i < len(files) && total > s.maxBytes; i++ {
+		log.Printf("Spool full, dropping oldest file %s", filepath.Base(files[i]))
+		os.Remove(files[i])
+		total -= sizes[i]
+	}
+}
+
+// files lists spool files oldest first (names are zero-padded nanosecond timestamps)
+func (s *spoolExporter) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+spoolFileExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func readSpoolFile(path string) ([]prompb.TimeSeries, error) {
+	compressed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing: %w", err)
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("unmarshaling: %w", err)
+	}
+	return req.Timeseries, nil
+}
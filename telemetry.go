@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readingsPerFetchBounds are the bucket upper bounds of ultrahuman_readings_per_fetch
+var readingsPerFetchBounds = []float64{0, 10, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// pushTelemetry counts samples across every push, for capacity planning
+type pushTelemetry struct {
+	mu         sync.Mutex
+	pushed     uint64
+	duplicates uint64
+	buckets    []uint64 // cumulative, aligned with readingsPerFetchBounds
+	sum        float64
+	count      uint64
+	cycle      int // samples produced by the fetch cycle in progress
+}
+
+var pushCounters = &pushTelemetry{buckets: make([]uint64, len(readingsPerFetchBounds))}
+
+// observe records one push: the new samples it produced, the readings skipped
+// as already pushed, and whether the write succeeded
+func (t *pushTelemetry) observe(samples, duplicates int, written bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cycle += samples
+	t.duplicates += uint64(duplicates)
+	if written {
+		t.pushed += uint64(samples)
+	}
+}
+
+// observeFetch adds the samples of the finished fetch cycle, across all its
+// dates and accounts, to the histogram
+func (t *pushTelemetry) observeFetch() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, bound := range readingsPerFetchBounds {
+		if float64(t.cycle) <= bound {
+			t.buckets[i]++
+		}
+	}
+	t.sum += float64(t.cycle)
+	t.count++
+	t.cycle = 0
+}
+
+// collectTelemetryFamilies builds the exporter's own gauges: fetch health and
+// how old the cached ring data is
+func collectTelemetryFamilies(cfg *Config) pullFamilies {
+	families := make(pullFamilies)
+
+	fetchStatusMu.Lock()
+	families.add("ultrahuman_exporter_fetches_total", "Fetch cycles run since start", true, nil, float64(fetchCount))
+	families.add("ultrahuman_exporter_consecutive_failures", "Fetch cycles failed in a row", false, nil, float64(consecutiveFailures))
+	up := 0.0
+	if !lastSuccessfulFetch.IsZero() && consecutiveFailures < max(cfg.DownAfter, 1) {
+		up = 1
+	}
+	families.add("ultrahuman_up", "1 unless the last --down-after fetches all failed", false, nil, up)
+	if !lastSuccessfulFetch.IsZero() {
+		families.add("ultrahuman_exporter_last_success_timestamp_seconds", "Unix time of the last successful fetch", false, nil, float64(lastSuccessfulFetch.Unix()))
+	}
+	fetchStatusMu.Unlock()
+
+	pushCounters.mu.Lock()
+	families.add("ultrahuman_samples_pushed_total", "Samples successfully written to the exporter", true, nil, float64(pushCounters.pushed))
+	families.add("ultrahuman_samples_deduplicated_total", "Readings skipped as already pushed", true, nil, float64(pushCounters.duplicates))
+	families.addHistogram("ultrahuman_readings_per_fetch", "New samples produced per fetch cycle", readingsPerFetchBounds, pushCounters.buckets, pushCounters.sum, pushCounters.count)
+	pushCounters.mu.Unlock()
+
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+
+	for _, account := range cfg.accounts() {
+		cached, ok := responseCache[account.Label]
+		if !ok {
+			continue
+		}
+		labels := account.seriesLabels(cfg.Labels)
+
+		stale := 0.0
+		if cached.stale {
+			stale = 1
+		}
+		families.add("ultrahuman_data_staleness_seconds", "Seconds since the last successful fetch", false, labels, time.Since(cached.fetchedAt).Seconds())
+		families.add("ultrahuman_data_stale", "1 if the last fetch failed and cached data is being served", false, labels, stale)
+	}
+	return families
+}
+
+// handleTelemetry serves only the exporter's own metrics, for --telemetry-listen
+func handleTelemetry(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheusText(w, collectTelemetryFamilies(cfg))
+	}
+}
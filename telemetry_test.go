@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadingsPerFetchObservedOncePerCycle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":200,"data":{"metrics":{
+			"2024-01-01":[{"type":"sleep_score","object":{"value":80,"day_start_timestamp":1704067200}}],
+			"2024-01-02":[{"type":"sleep_score","object":{"value":75,"day_start_timestamp":1704153600}}]}}}`))
+	}))
+	defer server.Close()
+
+	cfg := defaultConfig()
+	fetchers := newFetchers(cfg, server.Client(), server.URL)
+	pushCounters.mu.Lock()
+	pushCounters.cycle = 0 // left over from pushes outside a cycle in other tests
+	count, sum := pushCounters.count, pushCounters.sum
+	pushCounters.mu.Unlock()
+
+	if err := runFetchCycle(cfg, fetchers, &recordingExporter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pushCounters.mu.Lock()
+	defer pushCounters.mu.Unlock()
+	if got := pushCounters.count - count; got != 1 {
+		t.Errorf("histogram observed %d times for one cycle, want 1", got)
+	}
+	if got := pushCounters.sum - sum; got != 2 {
+		t.Errorf("cycle observed %g samples, want both days' 2", got)
+	}
+}
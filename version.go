@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+// Build information, set at build time with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc123 -X main.date=2026-01-01"
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("uh-ring %s (commit %s, built %s)", version, commit, date)
+}
+
+// defaultUserAgent identifies this tool in API and receiver logs
+func defaultUserAgent() string {
+	return "uh-ring-stats/" + version
+}
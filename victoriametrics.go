@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// VictoriaMetricsClient pushes every sample to VictoriaMetrics'
+// /api/v1/import/prometheus endpoint in the text exposition format, with
+// each sample's own timestamp in milliseconds
+type VictoriaMetricsClient struct {
+	url    string
+	client *http.Client
+}
+
+func NewVictoriaMetricsClient(baseURL string) *VictoriaMetricsClient {
+	return &VictoriaMetricsClient{
+		url:    strings.TrimRight(baseURL, "/") + "/api/v1/import/prometheus",
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *VictoriaMetricsClient) Write(timeseries []prompb.TimeSeries) error {
+	httpReq, err := http.NewRequest("POST", c.url, bytes.NewReader(renderImportLines(timeseries)))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "text/plain")
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("victoriametrics import failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// renderImportLines formats one `metric{labels} value timestamp_ms` line per sample
+func renderImportLines(timeseries []prompb.TimeSeries) []byte {
+	var b bytes.Buffer
+	for _, ts := range timeseries {
+		name := seriesName(ts)
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name != "__name__" {
+				labels[l.Name] = l.Value
+			}
+		}
+		for _, sample := range ts.Samples {
+			fmt.Fprintf(&b, "%s%s %g %d\n", name, formatLabelSet(labels), sample.Value, sample.Timestamp)
+		}
+	}
+	return b.Bytes()
+}